@@ -0,0 +1,181 @@
+package goagain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+)
+
+// The JSON body of every response from AdminHandler.
+type adminResult struct {
+	OK     bool         `json:"ok"`
+	Error  string       `json:"error,omitempty"`
+	Phase  string       `json:"phase,omitempty"`
+	Child  int          `json:"child,omitempty"`
+	PID    int          `json:"pid"`
+	Status *adminStatus `json:"status,omitempty"`
+}
+
+type adminStatus struct {
+	Phase             string    `json:"phase"`
+	Generation        int       `json:"generation"`
+	ParentPid         int       `json:"ppid"`
+	StartedAt         time.Time `json:"started_at"`
+	Relaunching       int       `json:"relaunching,omitempty"`
+	ActiveConnections int       `json:"active_connections"`
+	Metrics           Metrics   `json:"metrics"`
+	LastUpgradeError  string    `json:"last_upgrade_error,omitempty"`
+}
+
+// Return an http.Handler through which orchestration tooling can restart
+// this process and verify the outcome without signaling it:
+//
+//	POST /upgrade restarts as Restart does and reports whether the child took
+//	over, or in which phase it failed.
+//	POST /drain has Wait return as though it had received SIGQUIT.
+//	GET /status reports this process' phase and generation, the child in
+//	flight, if any, and RestartMetrics.
+//
+// Every response is JSON.  Wait must be running in another goroutine.  Serve
+// it only where the trusted may reach it, as ServeAdmin does.
+func AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		if !adminMethod(w, r, http.MethodPost) {
+			return
+		}
+		err := Restart()
+		res := adminResult{OK: nil == err, PID: os.Getpid()}
+		if nil != err {
+			res.Error = err.Error()
+			var rerr *RelaunchError
+			if errors.As(err, &rerr) {
+				res.Phase, res.Child = rerr.Phase, rerr.PID
+			}
+			writeAdmin(w, http.StatusInternalServerError, res)
+			return
+		}
+		writeAdmin(w, http.StatusOK, res)
+	})
+	mux.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		if !adminMethod(w, r, http.MethodPost) {
+			return
+		}
+		go InjectSignal(syscall.SIGQUIT)
+		writeAdmin(w, http.StatusAccepted, adminResult{OK: true, PID: os.Getpid()})
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if !adminMethod(w, r, http.MethodGet) {
+			return
+		}
+		ps := Status()
+		s := &adminStatus{
+			Phase:             ps.Phase,
+			Generation:        ps.Generation,
+			ParentPid:         ps.ParentPid,
+			StartedAt:         ps.StartedAt,
+			Relaunching:       ps.ChildPID,
+			ActiveConnections: ps.ActiveConnections,
+			Metrics:           RestartMetrics(),
+		}
+		if nil != ps.LastUpgradeError {
+			s.LastUpgradeError = ps.LastUpgradeError.Error()
+		}
+		writeAdmin(w, http.StatusOK, adminResult{OK: true, PID: os.Getpid(), Status: s})
+	})
+	return mux
+}
+
+// Serve AdminHandler on the given network and address, a Unix socket such
+// as "/run/app/admin.sock" if network is empty, until the returned io.Closer
+// is closed.  A Unix socket is created accessible only to this user.  Since
+// a child serves its own on the same path while its parent is still running,
+// the path is taken over from whatever's bound to it and is removed on Close
+// only if it's still this process'.  Close waits briefly for requests in
+// flight so a POST /upgrade that ends this process still gets its response;
+// defer it in main.
+func ServeAdmin(network, addr string) (io.Closer, error) {
+	if "" == network {
+		network = "unix"
+	}
+	as := &adminServer{srv: &http.Server{Handler: AdminHandler()}}
+	var l net.Listener
+	if "unix" == network {
+		if err := os.Remove(addr); nil != err && !os.IsNotExist(err) {
+			return nil, err
+		}
+		ul, err := net.ListenUnix("unix", &net.UnixAddr{Name: addr, Net: "unix"})
+		if nil != err {
+			return nil, err
+		}
+		ul.SetUnlinkOnClose(false)
+		fi, err := os.Stat(addr)
+		if nil == err {
+			err = os.Chmod(addr, 0600)
+		}
+		if nil != err {
+			ul.Close()
+			return nil, err
+		}
+		l, as.path, as.fi = ul, addr, fi
+	} else {
+		var err error
+		if l, err = net.Listen(network, addr); nil != err {
+			return nil, err
+		}
+	}
+	go as.srv.Serve(l)
+	return as, nil
+}
+
+// How long Close waits for admin requests in flight.
+const adminCloseTimeout = 5 * time.Second
+
+// An admin server and the path of its Unix socket, if it's bound to one.
+type adminServer struct {
+	srv  *http.Server
+	path string
+	fi   os.FileInfo
+}
+
+// Shut down, removing the Unix socket only if nothing has replaced it since
+// it was bound.
+func (as *adminServer) Close() error {
+	if "" != as.path {
+		if fi, err := os.Stat(as.path); nil == err && os.SameFile(as.fi, fi) {
+			os.Remove(as.path)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), adminCloseTimeout)
+	defer cancel()
+	if err := as.srv.Shutdown(ctx); context.DeadlineExceeded != err {
+		return err
+	}
+	return as.srv.Close()
+}
+
+func adminMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if method == r.Method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeAdmin(w, http.StatusMethodNotAllowed, adminResult{
+		Error: r.Method + " not allowed",
+		PID:   os.Getpid(),
+	})
+	return false
+}
+
+func writeAdmin(w http.ResponseWriter, code int, res adminResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(res); nil != err {
+		logln("writing admin response", err)
+	}
+}
@@ -0,0 +1,74 @@
+package goagain
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// AdoptOptions configures AdoptOrBind.
+type AdoptOptions struct {
+
+	// Network and Addr are bound if no address was recorded in the
+	// environment by a parent process.
+	Network, Addr string
+
+	// Retries is the number of times to retry a failed bind, waiting
+	// Backoff before the first retry and twice as long before each one
+	// after that.
+	Retries int
+	Backoff time.Duration
+}
+
+// Adopt the listener inherited from the parent process or, failing that,
+// bind a fresh one on the address the parent recorded in the environment
+// (or, failing that, on opts.Network and opts.Addr), retrying with
+// exponential backoff since the parent may not have let go of it yet.  This
+// is the single entry point for a process that may or may not be a child.
+func AdoptOrBind(opts AdoptOptions) (net.Listener, error) {
+	l, err := Listener()
+	if nil == err {
+		return l, nil
+	}
+	network, addr := opts.Network, opts.Addr
+	if n, a, ok := parseName(nameUnescaper.Replace(getenv(envKey("NAME")))); ok {
+		logln("not adopting inherited listener:", err)
+		network, addr = n, a
+	}
+	if "" == network || "" == addr {
+		return nil, fmt.Errorf("AdoptOrBind: no address to bind")
+	}
+	backoff := opts.Backoff
+	for i := 0; ; i++ {
+		l, err = listenFresh(network, addr)
+		if nil == err {
+			break
+		}
+		if i >= opts.Retries {
+			return nil, err
+		}
+		logln("retrying bind in", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if 0 <= conf().Linger {
+		if err := SetLinger(l, conf().Linger); nil != err {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Split a name as formatted by fileName into its network and address.
+func parseName(name string) (network, addr string, ok bool) {
+	if !strings.HasSuffix(name, "->") {
+		return
+	}
+	i := strings.Index(name, ":")
+	if -1 == i {
+		return
+	}
+	return name[:i], name[i+1 : len(name)-2], true
+}
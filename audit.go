@@ -0,0 +1,66 @@
+package goagain
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// AuditFile, if not empty, is the path to which the environment of
+	// every new process started by Exec or ForkExec is appended just
+	// before it's started.
+	AuditFile string
+
+	// AuditRedact names the environment variables whose values are
+	// replaced with REDACTED in AuditFile.
+	AuditRedact []string
+)
+
+func audit(argv0 string, env []string) error {
+	if "" == conf().AuditFile {
+		return nil
+	}
+	f, err := os.OpenFile(
+		conf().AuditFile,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0600,
+	)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(
+		w,
+		"# %s pid:%d %s\n",
+		time.Now().Format(time.RFC3339),
+		syscall.Getpid(),
+		argv0,
+	)
+	for _, kv := range redact(env) {
+		fmt.Fprintln(w, kv)
+	}
+	return w.Flush()
+}
+
+func redact(env []string) []string {
+	redacted := make([]string, 0, len(env))
+	for _, kv := range env {
+		key := kv
+		if i := strings.Index(kv, "="); -1 != i {
+			key = kv[:i]
+		}
+		for _, name := range conf().AuditRedact {
+			if name == key {
+				kv = key + "=REDACTED"
+				break
+			}
+		}
+		redacted = append(redacted, kv)
+	}
+	return redacted
+}
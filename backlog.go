@@ -0,0 +1,95 @@
+package goagain
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// OnBacklog, if not nil, makes Drain, DrainContext, DrainStreams, and Exit
+// accept every connection the kernel has already queued on the listener,
+// having completed its handshake, before closing the listener, which would
+// reset them, and call it with each, to be served as usual.  With
+// ConnMigration set and a child spawned, they're migrated to the child
+// instead, with no state.  Set it before calling Wait.
+var OnBacklog func(c net.Conn)
+
+// How long to wait for another queued connection before taking the backlog
+// to be empty.
+const backlogWait = 10 * time.Millisecond
+
+// Accept every connection already queued on the Acceptor, until none is
+// queued for a moment, call the function with each, and return how many
+// there were.  The Acceptor, or the listener a TrackingListener wraps, must
+// have a SetDeadline method, as *net.TCPListener and *net.UnixListener do.
+// Meanwhile, Accept called elsewhere may return a timeout.
+func AcceptBacklog(a Acceptor, handle func(c net.Conn)) (int, error) {
+	tl, _ := a.(*TrackingListener)
+	var l Acceptor = a
+	if nil != tl {
+		l = tl.Listener
+	}
+	dl, ok := l.(interface {
+		SetDeadline(time.Time) error
+	})
+	if !ok {
+		return 0, fmt.Errorf("can't accept the backlog of %T, which has no SetDeadline method", l)
+	}
+	defer dl.SetDeadline(time.Time{})
+	var n int
+	for {
+		if err := dl.SetDeadline(time.Now().Add(backlogWait)); nil != err {
+			return n, err
+		}
+		c, err := l.Accept()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if nil != err {
+			return n, err
+		}
+		if nil != tl {
+			c = tl.track(c)
+		}
+		n++
+		handle(c)
+	}
+	if 0 < n {
+		event(
+			"backlog",
+			[]interface{}{"listener", a.Addr().String(), "connections", n},
+			"accepted", n, "queued connections from", a.Addr(),
+		)
+	}
+	return n, nil
+}
+
+// Close the Acceptor, first taking its backlog if OnBacklog is set.
+func closeAcceptor(a Acceptor) error {
+	if nil != conf().OnBacklog {
+		if _, err := AcceptBacklog(a, takeBacklog); nil != err && !IsErrClosing(err) {
+			logln("accepting backlog", err)
+		}
+	}
+	if err := a.Close(); nil != err && !IsErrClosing(err) {
+		return err
+	}
+	return nil
+}
+
+// Migrate a queued connection to the child, if there is one to migrate it to,
+// or else hand it to OnBacklog.
+func takeBacklog(c net.Conn) {
+	if conf().ConnMigration {
+		err := MigrateConn(c, nil)
+		if nil == err {
+			return
+		}
+		if !errors.Is(err, ErrNoMigration) {
+			logln("migrating queued connection", err)
+		}
+	}
+	conf().OnBacklog(c)
+}
@@ -0,0 +1,81 @@
+package goagain
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Restart whenever the executable at the given path, or by default this
+// process' own, is replaced, until the context is done.  The path may be a
+// symlink, like /srv/app/current, that a deploy repoints.  The file is
+// checked every debounce interval and must have held still, with the same
+// SHA-256 digest, for a whole interval before Restart is called, so a
+// half-copied binary doesn't take over; Checksum, if set, is verified as
+// usual.  Wait must be running in another goroutine.  A restart that fails
+// is logged and not retried until the executable changes again.
+func WatchExecutable(ctx context.Context, path string, debounce time.Duration) error {
+	if "" == path {
+		var err error
+		if path, err = lookPath(); nil != err {
+			return err
+		}
+	}
+	fi, err := os.Stat(path)
+	if nil != err {
+		return err
+	}
+	running, err := fileDigest(path)
+	if nil != err {
+		return err
+	}
+	go watchExecutable(ctx, path, debounce, fi, running)
+	return nil
+}
+
+func watchExecutable(ctx context.Context, path string, debounce time.Duration, last os.FileInfo, running string) {
+	t := time.NewTicker(debounce)
+	defer t.Stop()
+	var pending string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		fi, err := os.Stat(path)
+		if nil != err {
+			pending = "" // Mid-rename, perhaps.
+			continue
+		}
+		if "" == pending && sameStat(last, fi) {
+			continue
+		}
+		last = fi
+		digest, err := fileDigest(path)
+		if nil != err || running == digest {
+			pending = ""
+			continue
+		}
+		if pending != digest {
+			pending = digest
+			continue
+		}
+		event(
+			"executable-changed",
+			[]interface{}{"path", path, "sha256", digest},
+			"executable", path, "changed; restarting",
+		)
+		pending, running = "", digest
+		if err := Restart(); nil != err {
+			logln("restarting for new executable", err)
+			continue
+		}
+		return
+	}
+}
+
+// Return whether two stats of a path describe the same, unmodified file.
+func sameStat(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
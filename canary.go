@@ -0,0 +1,97 @@
+package goagain
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrCanaryFailed is returned by CanaryRelaunch when the child is killed
+// instead of being allowed to take over.
+var ErrCanaryFailed = errors.New("goagain: canary failed")
+
+// Whether a canary window is open and whether the child has said it's ready
+// during it, guarded by mu.
+var canaryActive, canaryReady bool
+
+// Fork and exec a child as ForkExec does but let it serve alongside this
+// process as a canary for the given window before it takes over.  The error
+// rate is sampled throughout; if it ever exceeds the threshold, or if the
+// child never says it's ready, the child is killed, this process carries on,
+// and a *RelaunchError wrapping ErrCanaryFailed is returned.  Otherwise the handoff proceeds and Wait,
+// which must be running in another goroutine, returns as usual.
+func CanaryRelaunch(
+	l net.Listener,
+	window time.Duration,
+	errorRate func() float64,
+	threshold float64,
+) error {
+	setCanary(true)
+	if err := ForkExec(l); nil != err {
+		setCanary(false)
+		return &RelaunchError{Phase: PhaseSpawn, Err: err}
+	}
+	var pid int
+	if p := getChild(); nil != p {
+		pid = p.Pid
+	}
+	interval := window / 10
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	deadline := time.NewTimer(window)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-t.C:
+			if rate := errorRate(); rate > threshold {
+				logln("canary error rate", rate, "exceeds", threshold)
+				return failCanary(PhaseCanary, pid)
+			}
+		case <-deadline.C:
+			if !setCanary(false) {
+				logln("canary never became ready")
+				return failCanary(PhaseReady, pid)
+			}
+			event("canary", []interface{}{"child", pid}, "canary succeeded")
+			return kill(syscall.Getpid(), readySignal())
+		}
+	}
+}
+
+// Note that the child is ready, given the signal that said so, if a canary
+// window is open and report whether the handoff must therefore wait for the
+// window to close or for the Overlap to elapse.
+func deferHandoff(sig os.Signal) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if canaryActive {
+		logln("deferring handoff until the canary window closes")
+		canaryReady = true
+		return true
+	}
+	return startOverlap(sig)
+}
+
+func failCanary(phase string, pid int) error {
+	setCanary(false)
+	err := ErrCanaryFailed
+	if cerr := CancelRelaunch(); nil != cerr && ErrNoRelaunch != cerr {
+		err = cerr
+	}
+	return &RelaunchError{Phase: phase, PID: pid, Err: err}
+}
+
+// Open or close the canary window, reporting whether the child said it was
+// ready while it was open.
+func setCanary(active bool) (ready bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	ready = canaryReady
+	canaryActive, canaryReady = active, false
+	return
+}
@@ -0,0 +1,47 @@
+package goagain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned by Exec and ForkExec when the executable
+// doesn't match Checksum.
+var ErrChecksumMismatch = errors.New("goagain: executable checksum mismatch")
+
+// Checksum, if not empty, is the hex-encoded SHA-256 digest the executable
+// must have for Exec or ForkExec to run it, as from a deploy manifest.  This
+// keeps a tampered-with or half-copied binary from taking over.
+var Checksum string
+
+func verifyChecksum(argv0 string) error {
+	if "" == conf().Checksum {
+		return nil
+	}
+	digest, err := fileDigest(argv0)
+	if nil != err {
+		return err
+	}
+	if !strings.EqualFold(conf().Checksum, digest) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// Return the hex-encoded SHA-256 digest of the named file.
+func fileDigest(name string) (string, error) {
+	f, err := os.Open(name)
+	if nil != err {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); nil != err {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
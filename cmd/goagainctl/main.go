@@ -0,0 +1,223 @@
+// Command goagainctl upgrades, reloads, and stops a server that uses
+// goagain, waiting for the outcome, in place of kill -USR2 $(cat pid) in
+// deploy scripts.
+//
+//	goagainctl [-pidfile path | -admin socket] [-timeout d] upgrade|reload|stop|status
+//
+// Given -admin, the Unix socket served by goagain.ServeAdmin, upgrade and
+// stop go through the admin endpoint, which reports whether the child took
+// over.  Given -pidfile, the file kept by setting goagain.PIDFile, upgrade
+// sends SIGUSR2 and waits until the PID file names another running process,
+// so it suits the Single strategy; a Double-strategy server keeps its PID so
+// upgrade it with -admin.  reload sends SIGHUP and stop sends SIGQUIT and
+// waits for the process to exit.
+//
+// It exits 0 on success, 1 on failure or timeout, and 2 on misuse.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/rcrowley/goagain"
+)
+
+var (
+	pidfile = flag.String("pidfile", "", "PID file kept by the server")
+	admin   = flag.String("admin", "", "admin socket served by the server")
+	timeout = flag.Duration("timeout", time.Minute, "how long to wait for the outcome")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: goagainctl [-pidfile path | -admin socket] [-timeout d] upgrade|reload|stop|status")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if 1 != flag.NArg() || ("" == *pidfile) == ("" == *admin) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	var err error
+	switch flag.Arg(0) {
+	case "upgrade":
+		err = upgrade(ctx)
+	case "reload":
+		err = signal(ctx, syscall.SIGHUP)
+	case "stop":
+		err = stop(ctx)
+	case "status":
+		err = status(ctx)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if nil != err {
+		fmt.Fprintln(os.Stderr, "goagainctl:", err)
+		os.Exit(1)
+	}
+}
+
+// Restart the server and wait until the new generation is serving.
+func upgrade(ctx context.Context) error {
+	if "" != *admin {
+		res, err := call(ctx, http.MethodPost, "/upgrade")
+		if nil != err {
+			return err
+		}
+		fmt.Println("upgraded from", res["pid"])
+		return nil
+	}
+	pid, err := readPID()
+	if nil != err {
+		return err
+	}
+	if err := goagain.Signal(pid, goagain.SIGUSR2); nil != err {
+		return err
+	}
+	for {
+		if newPID, err := readPID(); nil == err && newPID != pid && nil == goagain.Signal(newPID, 0) {
+			fmt.Println("upgraded", pid, "to", newPID)
+			return nil
+		}
+		if err := sleep(ctx); nil != err {
+			return fmt.Errorf("process %d didn't hand off: %w", pid, err)
+		}
+	}
+}
+
+// Stop the server gracefully and wait until it's exited.
+func stop(ctx context.Context) error {
+	pid, err := serverPID(ctx)
+	if nil != err {
+		return err
+	}
+	if "" != *admin {
+		_, err = call(ctx, http.MethodPost, "/drain")
+	} else {
+		err = goagain.Signal(pid, goagain.SIGQUIT)
+	}
+	if nil != err {
+		return err
+	}
+	for nil == goagain.Signal(pid, 0) {
+		if err := sleep(ctx); nil != err {
+			return fmt.Errorf("process %d didn't exit: %w", pid, err)
+		}
+	}
+	fmt.Println("stopped", pid)
+	return nil
+}
+
+// Send a signal to the server.
+func signal(ctx context.Context, sig syscall.Signal) error {
+	pid, err := serverPID(ctx)
+	if nil != err {
+		return err
+	}
+	return goagain.Signal(pid, sig)
+}
+
+// Print the server's status as reported by the admin endpoint or else its
+// PID, failing if it's not running.
+func status(ctx context.Context) error {
+	if "" != *admin {
+		res, err := call(ctx, http.MethodGet, "/status")
+		if nil != err {
+			return err
+		}
+		b, err := json.MarshalIndent(res, "", "\t")
+		if nil != err {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	pid, err := readPID()
+	if nil != err {
+		return err
+	}
+	if err := goagain.Signal(pid, 0); nil != err {
+		return fmt.Errorf("process %d: %w", pid, err)
+	}
+	fmt.Println("running", pid)
+	return nil
+}
+
+// Return the server's PID from the PID file or the admin endpoint.
+func serverPID(ctx context.Context) (int, error) {
+	if "" == *admin {
+		return readPID()
+	}
+	res, err := call(ctx, http.MethodGet, "/status")
+	if nil != err {
+		return 0, err
+	}
+	pid, ok := res["pid"].(float64)
+	if !ok {
+		return 0, errors.New("admin endpoint didn't report a PID")
+	}
+	return int(pid), nil
+}
+
+func readPID() (int, error) {
+	b, err := os.ReadFile(*pidfile)
+	if nil != err {
+		return 0, err
+	}
+	return strconv.Atoi(string(bytes.TrimSpace(b)))
+}
+
+// Make a request of the admin endpoint and return its JSON response, or an
+// error if it reports failure.
+func call(ctx context.Context, method, path string) (map[string]interface{}, error) {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", *admin)
+		},
+	}}
+	req, err := http.NewRequestWithContext(ctx, method, "http://goagain"+path, nil)
+	if nil != err {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if nil != err {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if nil != err {
+		return nil, err
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(b, &res); nil != err {
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if ok, _ := res["ok"].(bool); !ok {
+		return nil, fmt.Errorf("%s %s: %v", method, path, res["error"])
+	}
+	return res, nil
+}
+
+func sleep(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(100 * time.Millisecond):
+		return nil
+	}
+}
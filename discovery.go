@@ -0,0 +1,30 @@
+package goagain
+
+var (
+	// Register, if not nil, is called by Kill to register this process
+	// with service discovery (or update its registration) before it
+	// signals the other process to complete a restart, so the service is
+	// never absent.  An error aborts the handoff.
+	Register func() error
+
+	// Deregister, if not nil, is called by Wait before it returns for a
+	// plain shutdown but not when the process is exiting because another
+	// has taken over and registered in its place.
+	Deregister func() error
+)
+
+// Whether this process is the child under the Double strategy, handing back
+// to its parent once the parent has re-executed, guarded by mu.
+var yielding bool
+
+func deregister() {
+	mu.Lock()
+	y := yielding
+	mu.Unlock()
+	if y || nil == conf().Deregister {
+		return
+	}
+	if err := conf().Deregister(); nil != err {
+		logln("Deregister:", err)
+	}
+}
@@ -0,0 +1,275 @@
+package goagain
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An Acceptor is what draining needs of a listener so that custom accept
+// loops can take part in a graceful shutdown.  Every net.Listener is an
+// Acceptor.
+type Acceptor interface {
+	Accept() (net.Conn, error)
+	Addr() net.Addr
+	Close() error
+}
+
+// ErrDrainTimeout is returned by WaitForConnections when connections remain
+// active after the timeout.
+var ErrDrainTimeout = errors.New("goagain: timed out waiting for connections")
+
+// The drain counter, which counts every connection (or request) tracked by
+// any means.
+var conns counter
+
+// Return the number of connections (or requests) currently counted as
+// active.
+func ActiveConnections() int {
+	return conns.count()
+}
+
+// Stop accepting connections by closing the Acceptor and then wait, as
+// WaitForConnections does, for those already accepted to finish.  Only the
+// Acceptor's own connections are waited for if it's a *TrackingListener.
+func Drain(a Acceptor, timeout time.Duration) error {
+	logln("draining", a.Addr())
+	defer drainStarted()()
+	if err := closeAcceptor(a); nil != err {
+		return err
+	}
+	if tl, ok := a.(*TrackingListener); ok {
+		return tl.c.wait(timeout)
+	}
+	return WaitForConnections(timeout)
+}
+
+// Stop accepting connections as Drain does but wait for those already
+// accepted to finish only until the context is done, returning its error if
+// it's done first.
+func DrainContext(ctx context.Context, a Acceptor) error {
+	logln("draining", a.Addr())
+	defer drainStarted()()
+	if err := closeAcceptor(a); nil != err {
+		return err
+	}
+	c := &conns
+	if tl, ok := a.(*TrackingListener); ok {
+		c = &tl.c
+	}
+	select {
+	case <-c.idleChan():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain the Acceptor as Drain does, remove PIDFile, and exit.  If
+// connections are still active after the timeout, forcibly close them, as
+// CloseConnections does, and exit anyway, with status 1, rather than let a
+// single slow client keep this process alive forever.
+func Exit(a Acceptor, timeout time.Duration) {
+	err := Drain(a, timeout)
+	if nil != err {
+		CloseConnections()
+	}
+	if err := RemovePIDFile(); nil != err {
+		logln("removing PID file", err)
+	}
+	if nil != err {
+		logln("forcing exit:", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Drain every Acceptor concurrently, each according to its own timeout, and
+// return the first error encountered, if any.
+func DrainAll(timeouts map[Acceptor]time.Duration) error {
+	var (
+		wg    sync.WaitGroup
+		errMu sync.Mutex
+		first error
+	)
+	for a, timeout := range timeouts {
+		wg.Add(1)
+		go func(a Acceptor, timeout time.Duration) {
+			defer wg.Done()
+			if err := Drain(a, timeout); nil != err {
+				logln("draining", a.Addr(), err)
+				errMu.Lock()
+				if nil == first {
+					first = err
+				}
+				errMu.Unlock()
+			}
+		}(a, timeout)
+	}
+	wg.Wait()
+	return first
+}
+
+// Block until no connections (or requests) are counted as active or until
+// the timeout elapses, whichever comes first.  A timeout of zero waits
+// forever.
+func WaitForConnections(timeout time.Duration) error {
+	return conns.wait(timeout)
+}
+
+// A TrackingListener counts the connections it accepts as active, both on
+// its own and in the drain counter, until they're closed.
+type TrackingListener struct {
+	net.Listener
+	c counter
+}
+
+// Wrap a listener so the connections it accepts are counted as active until
+// they're closed.
+func Track(l net.Listener) *TrackingListener {
+	return &TrackingListener{Listener: l}
+}
+
+// Accept a connection and count it as active.
+func (tl *TrackingListener) Accept() (net.Conn, error) {
+	c, err := tl.Listener.Accept()
+	if nil != err {
+		return nil, err
+	}
+	return tl.track(c), nil
+}
+
+// Count a connection accepted from the wrapped listener as active.
+func (tl *TrackingListener) track(c net.Conn) net.Conn {
+	tl.c.incr()
+	conns.incr()
+	tc := &trackedConn{Conn: c, tl: tl, accepted: time.Now()}
+	tc.active.Store(tc.accepted.UnixNano())
+	trackedMu.Lock()
+	tracked[tc] = struct{}{}
+	trackedMu.Unlock()
+	return tc
+}
+
+// Return the wrapped listener.
+func (tl *TrackingListener) Unwrap() net.Listener {
+	return tl.Listener
+}
+
+// Return the number of this listener's connections currently active.
+func (tl *TrackingListener) ActiveConnections() int {
+	return tl.c.count()
+}
+
+// Forcibly close this listener's active connections, as CloseConnections
+// does, and return how many there were.
+func (tl *TrackingListener) CloseConnections() int {
+	return closeTracked(tl)
+}
+
+// A counter of active connections (or requests) that can be waited on to
+// reach zero.
+type counter struct {
+	mu     sync.Mutex
+	active int
+	idle   []chan struct{}
+}
+
+func (c *counter) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+func (c *counter) decr() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	if 0 == c.active {
+		for _, ch := range c.idle {
+			close(ch)
+		}
+		c.idle = nil
+	}
+}
+
+func (c *counter) incr() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active++
+}
+
+// Return a channel that's closed once no connections are active, which may
+// be right away.
+func (c *counter) idleChan() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan struct{})
+	if 0 == c.active {
+		close(ch)
+	} else {
+		c.idle = append(c.idle, ch)
+	}
+	return ch
+}
+
+func (c *counter) wait(timeout time.Duration) error {
+	ch := c.idleChan()
+	if 0 == timeout {
+		<-ch
+		return nil
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-t.C:
+		return ErrDrainTimeout
+	}
+}
+
+// Every connection accepted by a TrackingListener and not yet closed.
+var (
+	trackedMu sync.Mutex
+	tracked   = make(map[*trackedConn]struct{})
+)
+
+type trackedConn struct {
+	net.Conn
+	tl       *TrackingListener
+	once     sync.Once
+	accepted time.Time
+	active   atomic.Int64 // when last read from or written to, in nanoseconds
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if 0 < n {
+		c.active.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if 0 < n {
+		c.active.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		trackedMu.Lock()
+		delete(tracked, c)
+		trackedMu.Unlock()
+		c.tl.c.decr()
+		conns.decr()
+	})
+	return c.Conn.Close()
+}
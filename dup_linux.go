@@ -0,0 +1,9 @@
+package goagain
+
+import "syscall"
+
+// Atomically make newfd a close-on-exec duplicate of oldfd, closing whatever
+// newfd was before.
+func dup2(oldfd, newfd int) error {
+	return syscall.Dup3(oldfd, newfd, syscall.O_CLOEXEC)
+}
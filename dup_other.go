@@ -0,0 +1,15 @@
+//go:build !linux && !windows
+
+package goagain
+
+import "syscall"
+
+// Atomically make newfd a close-on-exec duplicate of oldfd, closing whatever
+// newfd was before.
+func dup2(oldfd, newfd int) error {
+	if err := syscall.Dup2(oldfd, newfd); nil != err {
+		return err
+	}
+	syscall.CloseOnExec(newfd)
+	return nil
+}
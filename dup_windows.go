@@ -0,0 +1,6 @@
+package goagain
+
+// Windows has no dup2(2).
+func dup2(oldfd, newfd int) error {
+	return errNoInherit
+}
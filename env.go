@@ -0,0 +1,153 @@
+package goagain
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// The prefix of the name of every environment variable goagain sets and
+// reads.  See SetEnvPrefix.
+var envPrefix = "GOAGAIN_"
+
+// These are read without synchronization so set them before calling Wait.
+var (
+	// EnvAllow, if not empty, lists patterns, as path.Match takes, such as
+	// "APP_*", naming the only environment variables passed on to the
+	// processes Exec, ForkExec, and Master start, so secrets meant only
+	// for this process' launch don't leak into every generation after
+	// it.  goagain's own variables are always passed on.
+	EnvAllow []string
+
+	// EnvDeny lists patterns naming environment variables never passed
+	// on, even if EnvAllow names them.
+	EnvDeny []string
+
+	// EnvExtra lists "NAME=value" environment variables for the processes
+	// Exec, ForkExec, and Master start, in place of any of the same name.
+	EnvExtra []string
+)
+
+func init() {
+	loadEnv()
+}
+
+// Change the prefix of the name of every environment variable goagain sets
+// and reads from GOAGAIN_ so two programs that both use goagain, one running
+// the other, don't mistake each other's variables for their own.  The parent
+// and child must agree on the prefix so call SetEnvPrefix first thing in
+// main, before anything else in goagain.
+func SetEnvPrefix(prefix string) {
+	mu.Lock()
+	envPrefix = prefix
+	mu.Unlock()
+	loadEnv()
+}
+
+// Unset every environment variable goagain set or inherited so subprocesses
+// this process spawns don't inherit them.  Call it once this process has
+// taken over and recovered everything it inherited: afterwards, Listener,
+// Listeners, Manifest, and the like find nothing.  ForkExec and Exec set the
+// variables afresh for the next process.
+func CleanEnv() error {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		if err := os.Unsetenv(envName(kv)); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the environment for a new process: the given one, as EnvAllow and
+// EnvDeny filter it, and EnvExtra.
+func childEnv(env []string) []string {
+	if 0 == len(conf().EnvAllow) && 0 == len(conf().EnvDeny) && 0 == len(conf().EnvExtra) {
+		return env
+	}
+	extra := make(map[string]bool, len(conf().EnvExtra))
+	for _, kv := range conf().EnvExtra {
+		extra[envName(kv)] = true
+	}
+	var out []string
+	for _, kv := range env {
+		name := envName(kv)
+		if extra[name] {
+			continue
+		}
+		if !strings.HasPrefix(name, envPrefix) {
+			if 0 != len(conf().EnvAllow) && !matchEnv(conf().EnvAllow, name) || matchEnv(conf().EnvDeny, name) {
+				continue
+			}
+		}
+		out = append(out, kv)
+	}
+	return append(out, conf().EnvExtra...)
+}
+
+// The GOAGAIN_* variables for a new process, by full name, built up apart
+// from this process' own environment so that nothing else sees them half
+// set and no goroutine reading this process' own sees a child's.  An empty
+// value leaves a variable out.
+type envVars map[string]string
+
+func (v envVars) set(name, value string) {
+	v[envKey(name)] = value
+}
+
+// Return this process' environment with the variables in place of any of
+// the same name.
+func (v envVars) environ() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if _, ok := v[envName(kv)]; !ok {
+			env = append(env, kv)
+		}
+	}
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if "" != v[name] {
+			env = append(env, name+"="+v[name])
+		}
+	}
+	return env
+}
+
+func envName(kv string) string {
+	return kv[:strings.Index(kv+"=", "=")]
+}
+
+func matchEnv(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the name of the environment variable with the given suffix.
+func envKey(name string) string {
+	return envPrefix + name
+}
+
+// Read the environment variables that take effect as the process starts.
+func loadEnv() {
+	loadGeneration()
+	loadInherit()
+	loadObserve()
+	loadOrphan()
+	loadPIDFile()
+	loadPriority()
+	loadSocketOptions()
+	loadStats()
+}
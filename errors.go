@@ -0,0 +1,98 @@
+package goagain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The phases of a relaunch reported by RelaunchError.
+const (
+	PhaseSpawn  = "spawn"  // starting the child
+	PhaseReady  = "ready"  // waiting for the child to say it's ready
+	PhaseCanary = "canary" // watching the child serve alongside the parent
+)
+
+var (
+	// ErrNotInherited is returned when a listener or file wasn't handed
+	// down by a parent process, as when this process wasn't started by
+	// one.
+	ErrNotInherited = errors.New("goagain: not inherited")
+
+	// ErrParentMismatch is returned by Kill when the parent process named
+	// in the environment is no longer this process' parent, as when it
+	// has died and its PID may have been reused.
+	ErrParentMismatch = errors.New("goagain: parent process mismatch")
+
+	// ErrChildFailed matches a *ChildExitError by way of errors.Is.
+	ErrChildFailed = errors.New("goagain: child exited before taking over")
+
+	// ErrHandoffTimeout is wrapped in the *RelaunchError reported when a
+	// child doesn't say it's ready in time.
+	ErrHandoffTimeout = errors.New("goagain: timed out waiting for child")
+)
+
+// A ChildExitError reports a child process that exited before taking over.
+type ChildExitError struct {
+	PID      int
+	ExitCode int // -1 if the child was killed by a signal
+}
+
+func (e *ChildExitError) Error() string {
+	return fmt.Sprintf(
+		"goagain: child %d exited before taking over with status %d",
+		e.PID,
+		e.ExitCode,
+	)
+}
+
+// Match ErrChildFailed.
+func (e *ChildExitError) Is(target error) bool {
+	return ErrChildFailed == target
+}
+
+// An InheritError reports an inherited file descriptor that isn't the
+// listener the parent process said it would be: closed, not a socket, not
+// listening, or bound to another address than its name says.
+type InheritError struct {
+	FD   uintptr
+	Name string
+	Err  error
+}
+
+func (e *InheritError) Error() string {
+	return fmt.Sprintf(
+		"goagain: inherited file descriptor %d for %s: %v",
+		e.FD,
+		e.Name,
+		e.Err,
+	)
+}
+
+func (e *InheritError) Unwrap() error {
+	return e.Err
+}
+
+// A RelaunchError is returned by the higher-level relaunch functions to say
+// which phase failed and which child, if any, was spawned so the caller can
+// clean up.
+type RelaunchError struct {
+	Phase string
+	PID   int // zero if no child was spawned
+	Err   error
+}
+
+func (e *RelaunchError) Error() string {
+	if 0 == e.PID {
+		return fmt.Sprintf("goagain: relaunch failed in %s phase: %v", e.Phase, e.Err)
+	}
+	return fmt.Sprintf(
+		"goagain: relaunch failed in %s phase with child %d: %v",
+		e.Phase,
+		e.PID,
+		e.Err,
+	)
+}
+
+func (e *RelaunchError) Unwrap() error {
+	return e.Err
+}
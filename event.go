@@ -0,0 +1,50 @@
+package goagain
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type eventFormat int
+
+// The formats lifecycle events may be reported in.
+const (
+	// HumanEvents are reported by way of Log or the log package.
+	HumanEvents eventFormat = 1 << iota
+
+	// MachineEvents are reported on standard error as single lines like
+	// "GOAGAIN-EVENT spawn child=1234" so wrapper scripts can grep for them.
+	MachineEvents
+)
+
+// Events selects the formats lifecycle events are reported in; combine
+// HumanEvents and MachineEvents to report both ways.
+var Events = HumanEvents
+
+// Report an event: v as log.Println would and the event's name followed by
+// the key-value pairs in kv as a machine-readable line and to every Watcher.
+func event(name string, kv []interface{}, v ...interface{}) {
+	notifyWatchers(name, kv)
+	if 0 != conf().Events&HumanEvents {
+		if el, ok := conf().Log.(interface {
+			Event(name string, kv []interface{})
+		}); ok {
+			el.Event(name, kv)
+		} else {
+			logln(v...)
+		}
+	}
+	if 0 != conf().Events&MachineEvents {
+		fields := []string{"GOAGAIN-EVENT", name}
+		for i := 0; i+1 < len(kv); i += 2 {
+			value := fmt.Sprint(kv[i+1])
+			if strings.ContainsAny(value, " \t\n\"=") {
+				value = strconv.Quote(value)
+			}
+			fields = append(fields, fmt.Sprintf("%v=%s", kv[i], value))
+		}
+		fmt.Fprintln(os.Stderr, strings.Join(fields, " "))
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/rcrowley/goagain/httpserver"
+)
+
+func init() {
+	log.SetFlags(log.Lmicroseconds | log.Lshortfile)
+	log.SetPrefix(fmt.Sprintf("pid:%d ", syscall.Getpid()))
+}
+
+func main() {
+	srv := &http.Server{
+		Addr: "127.0.0.1:48879",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "Hello, world!")
+		}),
+	}
+
+	// Serve until signaled, restarting without downtime on SIGUSR2 and
+	// giving requests in flight up to ten seconds to finish on the way out.
+	if err := httpserver.ListenAndServe(srv, 10*time.Second); nil != err {
+		log.Fatalln(err)
+	}
+
+}
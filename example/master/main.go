@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/rcrowley/goagain"
+)
+
+func init() {
+	log.SetFlags(log.Lmicroseconds | log.Lshortfile)
+	log.SetPrefix(fmt.Sprintf("pid:%d ", syscall.Getpid()))
+}
+
+func main() {
+
+	// Workers accept connections from the listeners their master holds.
+	if id, ok := goagain.Worker(); ok {
+		ls, err := goagain.Listeners()
+		if nil != err {
+			log.Fatalln(err)
+		}
+		for _, l := range ls {
+			log.Println("worker", id, "accepting on", l.Addr())
+			go serve(l)
+		}
+		goagain.WaitWorker()
+		for _, l := range ls {
+			l.Close()
+		}
+		time.Sleep(1e9)
+		return
+	}
+
+	// The master inherits its listener from the master it's replacing or
+	// listens anew.
+	l, err := goagain.Listen("tcp", "127.0.0.1:48879")
+	if nil != err {
+		log.Fatalln(err)
+	}
+	log.Println("master listening on", l.Addr())
+
+	// Run four workers until signaled to stop.
+	if _, err := goagain.NewMaster(4, l).Run(); nil != err {
+		log.Fatalln(err)
+	}
+	l.Close()
+
+}
+
+// A very rude server that says hello and then closes your connection.
+func serve(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if nil != err {
+			if goagain.IsErrClosing(err) {
+				break
+			}
+			log.Fatalln(err)
+		}
+		fmt.Fprintf(c, "Hello, world from %d!\n", os.Getpid())
+		c.Close()
+	}
+}
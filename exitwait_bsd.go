@@ -0,0 +1,42 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package goagain
+
+import (
+	"context"
+	"syscall"
+	"time"
+)
+
+// Wait for the given process to exit with kqueue(2)'s EVFILT_PROC, checking
+// the context every so often.
+func waitExit(ctx context.Context, pid int) error {
+	kq, err := syscall.Kqueue()
+	if nil != err {
+		return errNoWaitExit
+	}
+	defer syscall.Close(kq)
+	var change syscall.Kevent_t
+	syscall.SetKevent(&change, pid, syscall.EVFILT_PROC, syscall.EV_ADD|syscall.EV_ONESHOT)
+	change.Fflags = syscall.NOTE_EXIT
+	events := make([]syscall.Kevent_t, 1)
+	if _, err := syscall.Kevent(kq, []syscall.Kevent_t{change}, nil, nil); nil != err {
+		if syscall.ESRCH == err {
+			return nil
+		}
+		return errNoWaitExit
+	}
+	timeout := syscall.NsecToTimespec(int64(100 * time.Millisecond))
+	for {
+		n, err := syscall.Kevent(kq, nil, events, &timeout)
+		if nil != err && syscall.EINTR != err {
+			return err
+		}
+		if 0 < n {
+			return nil
+		}
+		if err := ctx.Err(); nil != err {
+			return err
+		}
+	}
+}
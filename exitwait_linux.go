@@ -0,0 +1,48 @@
+package goagain
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+const sysPidfdOpen = 434 // The same on every architecture.
+
+// Wait for the given process to exit by polling a pidfd(2), which becomes
+// readable once it has, in the runtime's network poller.
+func waitExit(ctx context.Context, pid int) error {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if syscall.ESRCH == errno {
+		return nil
+	}
+	if 0 != errno {
+		return errNoWaitExit
+	}
+	if err := syscall.SetNonblock(int(fd), true); nil != err {
+		syscall.Close(int(fd))
+		return errNoWaitExit
+	}
+	f := os.NewFile(fd, "pidfd")
+	defer f.Close()
+	rc, err := f.SyscallConn()
+	if nil != err {
+		return errNoWaitExit
+	}
+	stop := context.AfterFunc(ctx, func() { f.SetReadDeadline(time.Now()) })
+	defer stop()
+	first := true
+	if err := rc.Read(func(uintptr) bool {
+		if first {
+			first = false
+			return false // Wait for readability.
+		}
+		return true
+	}); nil != err {
+		if nil != ctx.Err() {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+
+package goagain
+
+import "context"
+
+func waitExit(ctx context.Context, pid int) error {
+	return errNoWaitExit
+}
@@ -0,0 +1,28 @@
+package goagain
+
+import (
+	"context"
+	"syscall"
+)
+
+// Wait for the given process to exit by waiting on its handle, checking the
+// context every so often.
+func waitExit(ctx context.Context, pid int) error {
+	h, err := syscall.OpenProcess(syscall.SYNCHRONIZE, false, uint32(pid))
+	if nil != err {
+		return nil // Gone already, most likely.
+	}
+	defer syscall.CloseHandle(h)
+	for {
+		ev, err := syscall.WaitForSingleObject(h, 100)
+		if nil != err {
+			return err
+		}
+		if syscall.WAIT_TIMEOUT != ev {
+			return nil
+		}
+		if err := ctx.Err(); nil != err {
+			return err
+		}
+	}
+}
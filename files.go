@@ -0,0 +1,69 @@
+package goagain
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Files other than listeners handed to the new process on restart, keyed by
+// purpose as recorded in the manifest, guarded by mu.
+var extraFiles = make(map[string]*os.File)
+
+// Hand the given log file to the new process on restart so it can carry on
+// writing to the very same open file without losing lines to a race with
+// log rotation.  The child recovers it by name with LogFile.
+func AddLogFile(name string, f *os.File) {
+	addFile("log/"+name, f)
+}
+
+// Recover the log file added by name with AddLogFile in the parent process
+// and add it again so it's handed on at the next restart, too.
+func LogFile(name string) (*os.File, error) {
+	return inheritedFile("log/" + name)
+}
+
+// Hand the given file, which may be anything with a file descriptor such as
+// a lock file or a socket, to the new process on restart.  The child
+// recovers it by name with File.  The name mustn't contain a comma or an
+// equals sign.
+func AddFile(name string, f *os.File) error {
+	if "" == name || strings.ContainsAny(name, ",=") {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	addFile("file/"+name, f)
+	return nil
+}
+
+// Recover the file added by name with AddFile in the parent process and add
+// it again so it's handed on at the next restart, too.
+func File(name string) (*os.File, error) {
+	return inheritedFile("file/" + name)
+}
+
+func addFile(purpose string, f *os.File) {
+	mu.Lock()
+	defer mu.Unlock()
+	extraFiles[purpose] = f
+}
+
+func removeFile(purpose string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(extraFiles, purpose)
+}
+
+func inheritedFile(purpose string) (*os.File, error) {
+	manifest, err := Manifest()
+	if nil != err {
+		return nil, err
+	}
+	fd, ok := manifest[purpose]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", purpose, ErrNotInherited)
+	}
+	closeOnExec(fd)
+	f := os.NewFile(fd, purpose)
+	addFile(purpose, f)
+	return f, nil
+}
@@ -0,0 +1,48 @@
+package goagain
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// This process' generation, the PID of the process it took over from, and
+// the time it started, all read from the environment at startup.
+var (
+	generation int
+	parentPid  int
+	startedAt  = time.Now()
+)
+
+func loadGeneration() {
+	generation, parentPid = 0, 0
+	fmt.Sscan(os.Getenv(envKey("GENERATION")), &generation)
+	if _, err := fmt.Sscan(os.Getenv(envKey("PID")), &parentPid); nil != err {
+		fmt.Sscan(os.Getenv(envKey("PPID")), &parentPid)
+	}
+}
+
+// Return the number of times the listeners have been handed to a new process
+// since the process that bound them was started: zero in that process, one
+// in its first successor, and so on.  Under the Double strategy, each restart
+// hands them over twice.
+func Generation() int {
+	return generation
+}
+
+// Return the PID of the process this one took over from or zero if it was
+// started afresh.  Under the Double strategy, that's the child that handed
+// the listeners back.
+func ParentPid() int {
+	return parentPid
+}
+
+// Return the time this process started.
+func StartedAt() time.Time {
+	return startedAt
+}
+
+// Set the generation for the new process.  Call with mu held.
+func setGenerationEnv(v envVars) {
+	v.set("GENERATION", fmt.Sprint(generation+1))
+}
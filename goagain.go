@@ -2,14 +2,17 @@
 package goagain
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
 	"os/exec"
-	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
 type strategy int
@@ -23,6 +26,28 @@ const (
 	// inherited net.Listener; child signals parent to exec (second); parent
 	// kills child.
 	Double
+
+	// The Supervised strategy: goagain never forks or execs; SIGUSR2 ends
+	// Wait just as SIGQUIT would so the process exits gracefully in the
+	// foreground and its supervisor (runit, daemontools) starts it again.
+	Supervised
+
+	// The InPlace strategy: SIGUSR2 ends Wait without forking so the
+	// caller can stop accepting, drain, and call Exec, which replaces this
+	// image with a new one in the very same process.  The listener stays
+	// open throughout so connections queue in its backlog rather than
+	// being refused, and the PID never changes, which suits supervisors
+	// like runit and daemontools that need their child to stay put.
+	InPlace
+
+	// The ReusePort strategy: no file descriptors are passed.  Listen and
+	// ListenPacket bind with SO_REUSEPORT so the child forked and execed
+	// on SIGUSR2 binds the same addresses alongside its parent and, once
+	// ready, signals the parent to stop accepting and drain.  Connections
+	// still queued on the parent's listener when it's closed are reset
+	// (on Linux, where each listener has its own queue) so stop accepting
+	// only once the child is serving, as Wait returning implies.
+	ReusePort
 )
 
 // Don't make the caller import syscall.
@@ -30,9 +55,10 @@ const (
 	SIGINT  = syscall.SIGINT
 	SIGQUIT = syscall.SIGQUIT
 	SIGTERM = syscall.SIGTERM
-	SIGUSR2 = syscall.SIGUSR2
+	SIGUSR2 = sigUSR2
 )
 
+// These are read without synchronization so set them before calling Wait.
 var (
 	// OnSIGHUP is the function called when the server receives a SIGHUP
 	// signal. The normal use case for SIGHUP is to reload the
@@ -44,246 +70,1092 @@ var (
 	// log files.
 	OnSIGUSR1 func(l net.Listener) error
 
+	// OnHookError, if not nil, is called with the name of the hook and the
+	// error whenever OnSIGHUP, OnSIGUSR1, OnParentExit, PreHandoff, one of
+	// a Manager's hooks, a function registered with HandleSIGHUP, or a
+	// LogManager fails, in place of logging the error.
+	OnHookError func(name string, err error)
+
+	// PreHandoff is the function called in the parent once its child is
+	// ready to take over, just before Wait returns.  It runs while the
+	// parent is still accepting connections, so before the caller stops
+	// accepting and drains; use it to flush buffered data.
+	PreHandoff func(l net.Listener) error
+
+	// OnBeforeExec is the function called by Exec and ForkExec before
+	// they exec or spawn anything, to flush caches, say.  An error aborts
+	// the restart.
+	OnBeforeExec func() error
+
+	// OnChildSpawned is the function called by ForkExec with the PID of
+	// the child it just spawned, to record it for monitoring, say.  An
+	// error kills the child and aborts the restart.
+	OnChildSpawned func(pid int) error
+
+	// OnParentExit is the function called in the parent once its child is
+	// ready to take over, before PreHandoff, to hold the parent until an
+	// external load balancer has drained it, say.  An error kills the
+	// child instead and Wait carries on.
+	OnParentExit func() error
+
 	// The strategy to use; Single by default.
 	Strategy strategy = Single
+
+	// PreReady is the function called by Kill before this process tells
+	// the other it's ready to take over.  Only listeners and files added
+	// explicitly are handed from parent to child; everything else, like
+	// connections to upstream servers, is closed on exec and must be
+	// rebuilt, which is PreReady's job.  An error aborts the handoff.
+	PreReady func() error
+
+	// Executable, if not empty, is the program Exec and ForkExec run in
+	// place of this one.  It may be an entirely different program so long
+	// as it speaks the protocol of GOAGAIN_* environment variables
+	// described in the README.
+	Executable string
+
+	// Args, if not nil, is the argument list, starting with argv[0], that
+	// Exec and ForkExec pass the program they run in place of os.Args.
+	// Unless Executable is set, the program is found by looking up
+	// argv[0] in PATH.
+	Args []string
+
+	// Dir, if not empty, is the working directory of the processes
+	// ForkExec and Master spawn in place of this process' own.
+	Dir string
+
+	// SysProcAttr, if not nil, holds the operating system-specific
+	// attributes of the processes ForkExec and Master spawn: Setsid,
+	// Pdeathsig, Chroot, and so on.  Its Credential runs them as another
+	// user, to drop privileges once the parent has bound privileged ports,
+	// which suits Master's workers; a child that ForkExec runs as another
+	// user can't signal its parent to take over.
+	SysProcAttr *syscall.SysProcAttr
+
+	// ReadySignal, if not zero, is the signal a child sends its parent to
+	// say it's ready to take over in place of SIGQUIT or, under the Double
+	// strategy, SIGUSR2.  See RTSignal.
+	ReadySignal syscall.Signal
+
+	// SignalMap, if not nil, remaps the signals Wait receives before it
+	// acts on them: a signal mapped to another is handled as that one and
+	// a signal mapped to zero is ignored.  Signals that appear only as
+	// keys are handled, too.  Map SIGTERM to SIGQUIT, for example, to shut
+	// down gracefully under Kubernetes, or SIGWINCH to SIGUSR2 and SIGUSR2
+	// to zero where a supervisor reserves SIGUSR2.  The signal a child
+	// sends its parent to take over is never remapped.
+	SignalMap map[syscall.Signal]syscall.Signal
+
+	// RestartWhenIdle, if not zero, is the longest SIGUSR2 waits for the
+	// drain counter to reach zero before forking and re-executing so that
+	// restarts happen between connections rather than in the middle of
+	// them.  Wait handles no other signals in the meantime.
+	RestartWhenIdle time.Duration
+
+	// ReadyTimeout, if not zero, is the longest Wait gives the child it
+	// spawns on SIGUSR2 to say it's ready to take over.  A child that's
+	// still silent then is killed, as by CancelRelaunch, and this process
+	// carries on serving.
+	ReadyTimeout time.Duration
+
+	// ChildStdin, ChildStdout, and ChildStderr are the standard streams
+	// given to the child process spawned by ForkExec.  Each defaults to
+	// the parent's own when nil; point them at /dev/null or a log file to
+	// redirect the child.
+	ChildStdin, ChildStdout, ChildStderr *os.File
+
+	// ChildStreams, if not nil, is called before every process ForkExec
+	// and Master start for its standard streams, in place of ChildStdin,
+	// ChildStdout, and ChildStderr, so each generation can get a log file
+	// reopened afresh or a new pipe to a logging sidecar.  A nil file
+	// falls back as usual.  The files it returns, other than this
+	// process' own standard streams, are closed once the process has
+	// started.
+	ChildStreams func() (stdin, stdout, stderr *os.File, err error)
+)
+
+// ErrNoRelaunch is returned by CancelRelaunch when no child process is in
+// the middle of taking over.
+var ErrNoRelaunch = errors.New("goagain: no relaunch in progress")
+
+// Signals fed to Wait by InjectSignal.
+var injected = make(chan os.Signal)
+
+// The child process spawned by ForkExec that hasn't yet taken over and a
+// channel closed once it has been reaped.  mu also serializes access to the
+// GOAGAIN_* environment variables, which are set and read as a group.
+var (
+	mu     sync.Mutex
+	child  *os.Process
+	reaped chan struct{}
 )
 
-// Re-exec this same image without dropping the net.Listener.
-func Exec(l net.Listener) error {
+// Kill the child process spawned by ForkExec before it takes over and carry
+// on as though SIGUSR2 had never been received.
+func CancelRelaunch() error {
+	mu.Lock()
+	p, done := child, reaped
+	child = nil
+	if nil != p {
+		restartFailed()
+		forgetChild()
+	}
+	mu.Unlock()
+	if nil == p {
+		return ErrNoRelaunch
+	}
+	finishRestart(&RelaunchError{
+		Phase: PhaseReady,
+		PID:   p.Pid,
+		Err:   errors.New("relaunch canceled"),
+	})
+	event("cancel", []interface{}{"child", p.Pid}, "killing child", p.Pid)
+	if err := p.Kill(); nil != err && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	<-done
+	return nil
+}
+
+// Send a signal to a process that's waiting for it in Wait or elsewhere in
+// goagain.  On Windows, which lacks most signals, it sets a named event the
+// process created to stand in for the signal.
+func Signal(pid int, sig syscall.Signal) error {
+	return kill(pid, sig)
+}
+
+// Re-exec this same image without dropping the given listeners or any
+// Manager's listeners.
+func Exec(ls ...net.Listener) error {
+	if nil != conf().OnBeforeExec {
+		if err := conf().OnBeforeExec(); nil != err {
+			return err
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
 	var pid int
-	fmt.Sscan(os.Getenv("GOAGAIN_PID"), &pid)
+	fmt.Sscan(os.Getenv(envKey("PID")), &pid)
 	if syscall.Getppid() == pid {
 		return fmt.Errorf("goagain.Exec called by a child process")
 	}
-	argv0, err := lookPath()
+	argv0, args, err := command()
 	if nil != err {
 		return err
 	}
-	if _, err := setEnvs(l); nil != err {
+	if err := verifyChecksum(argv0); nil != err {
 		return err
 	}
-	if err := os.Setenv(
-		"GOAGAIN_SIGNAL",
-		fmt.Sprintf("%d", syscall.SIGQUIT),
-	); nil != err {
+	v := make(envVars)
+	fds, err := setEnvs(v, ls)
+	if nil != err {
 		return err
 	}
-	log.Println("re-executing", argv0)
-	return syscall.Exec(argv0, os.Args, os.Environ())
+	defer closeFDs(fds)
+	for _, fd := range fds {
+		if err := noCloseOnExec(fd); nil != err {
+			return err
+		}
+	}
+	v.set("SIGNAL", fmt.Sprintf("%d", syscall.SIGQUIT))
+
+	// Replaced in place, the new image has no other process to signal.
+	// Otherwise, under the Double strategy, it signals the child.
+	if InPlace == conf().Strategy {
+		v.set("PID", "")
+		v.set("PPID", "")
+	} else if nil != child {
+		v.set("PID", fmt.Sprint(child.Pid))
+	} else {
+		v.set("PID", "")
+	}
+	env := childEnv(v.environ())
+	if err := audit(argv0, env); nil != err {
+		return err
+	}
+	event("exec", []interface{}{"path", argv0}, "re-executing", argv0)
+	return syscall.Exec(argv0, args, env)
 }
 
-// Fork and exec this same image without dropping the net.Listener.
-func ForkExec(l net.Listener) error {
-	argv0, err := lookPath()
-	if nil != err {
+// Fork and exec this same image without dropping the given listeners or any
+// Manager's listeners.  Only one child may be in flight at a time; while one
+// is, ForkExec returns ErrRelaunchInProgress.
+func ForkExec(ls ...net.Listener) error {
+	if nil != getChild() {
+		return ErrRelaunchInProgress
+	}
+	if err := throttle(); nil != err {
 		return err
 	}
-	wd, err := os.Getwd()
+	if nil != conf().OnBeforeExec {
+		if err := conf().OnBeforeExec(); nil != err {
+			return err
+		}
+	}
+	countMetric(func(m *Metrics) { m.UpgradesAttempted++ })
+	pid, err := forkExec(ls)
 	if nil != err {
+		mu.Lock()
+		restartFailed()
+		mu.Unlock()
 		return err
 	}
-	fd, err := setEnvs(l)
+	if nil != conf().OnChildSpawned {
+		if err := conf().OnChildSpawned(pid); nil != err {
+			CancelRelaunch()
+			return err
+		}
+	}
+	return nil
+}
+
+func forkExec(ls []net.Listener) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if nil != child {
+		return 0, ErrRelaunchInProgress
+	}
+	argv0, args, err := command()
 	if nil != err {
-		return err
+		return 0, err
 	}
-	if err := os.Setenv("GOAGAIN_PID", ""); nil != err {
-		return err
+	if err := verifyChecksum(argv0); nil != err {
+		return 0, err
 	}
-	if err := os.Setenv(
-		"GOAGAIN_PPID",
-		fmt.Sprint(syscall.Getpid()),
-	); nil != err {
-		return err
+	wd, err := workDir()
+	if nil != err {
+		return 0, err
 	}
-	var sig syscall.Signal
-	if Double == Strategy {
-		sig = syscall.SIGUSR2
-	} else {
-		sig = syscall.SIGQUIT
+	state, err := openState()
+	if nil != err {
+		return 0, err
 	}
-	if err := os.Setenv("GOAGAIN_SIGNAL", fmt.Sprintf("%d", sig)); nil != err {
-		return err
+	defer state.close()
+
+	// Closed once the child's been reaped or, if it's never spawned, on
+	// return.
+	done, spawned := make(chan struct{}), false
+	defer func() {
+		if !spawned {
+			close(done)
+		}
+	}()
+	vw, err := openVersionPipe()
+	if nil != err {
+		return 0, err
 	}
-	files := make([]*os.File, fd+1)
-	files[syscall.Stdin] = os.Stdin
-	files[syscall.Stdout] = os.Stdout
-	files[syscall.Stderr] = os.Stderr
-	addr := l.Addr()
-	files[fd] = os.NewFile(
-		fd,
-		fmt.Sprintf("%s:%s->", addr.Network(), addr.String()),
-	)
-	p, err := os.StartProcess(argv0, os.Args, &os.ProcAttr{
-		Dir:   wd,
-		Env:   os.Environ(),
-		Files: files,
-		Sys:   &syscall.SysProcAttr{},
-	})
+	defer func() { closeVersionPipe(vw, spawned) }()
+	mf, err := openMigration()
 	if nil != err {
-		return err
+		return 0, err
 	}
-	log.Println("spawned child", p.Pid)
-	if err = os.Setenv("GOAGAIN_PID", fmt.Sprint(p.Pid)); nil != err {
-		return err
+	defer func() { closeMigration(mf, spawned) }()
+	qfs, err := openQUIC()
+	if nil != err {
+		return 0, err
 	}
-	return nil
+	defer func() { closeQUIC(qfs, spawned) }()
+	envLs := ls
+	if conf().SocketHandoff || ReusePort == conf().Strategy {
+		envLs = nil
+	}
+	v := make(envVars)
+	fds, err := setEnvs(v, envLs)
+	if nil != err {
+		return 0, err
+	}
+	defer closeFDs(fds)
+	if conf().SocketHandoff && ReusePort != conf().Strategy {
+		path, err := serveHandoff(ls, done)
+		if nil != err {
+			return 0, err
+		}
+		v.set("SOCKET", path)
+	}
+	v.set("PID", "")
+	v.set("PPID", fmt.Sprint(syscall.Getpid()))
+	v.set("SIGNAL", fmt.Sprintf("%d", readySignal()))
+	v.set("SPAWNED", markSpawned())
+	v.set("NONCE", nonce)
+
+	env := childEnv(v.environ())
+	if err := audit(argv0, env); nil != err {
+		return 0, err
+	}
+	std, closeStd, err := childStreams()
+	if nil != err {
+		return 0, err
+	}
+	pid, err := spawn(argv0, args, env, wd, std, fds)
+	closeStd()
+	if nil != err {
+		return 0, err
+	}
+	p, err := os.FindProcess(pid)
+	if nil != err {
+		return 0, err
+	}
+	event("spawn", []interface{}{"child", p.Pid}, "spawned child", p.Pid)
+	child, reaped, spawned = p, done, true
+	go supervise(p, reaped)
+	renamePIDFile()
+	state.hand()
+	return p.Pid, nil
 }
 
-// Test whether an error is equivalent to net.errClosing as returned by
-// Accept during a graceful exit.
+// Test whether an error is net.ErrClosed as returned by Accept during a
+// graceful exit.  errors.Is(err, net.ErrClosed) is equivalent.
 func IsErrClosing(err error) bool {
-	if opErr, ok := err.(*net.OpError); ok {
-		err = opErr.Err
+	return errors.Is(err, net.ErrClosed)
+}
+
+// Accept connections from the listener and handle each in a goroutine of its
+// own until the listener's closed, as it is for a graceful exit, and then
+// return nil.  Temporary errors, as when this process runs out of file
+// descriptors, are retried after a growing delay, as net/http does; any other
+// error is returned.
+func AcceptLoop(l net.Listener, handle func(c net.Conn)) error {
+	var delay time.Duration
+	for {
+		c, err := l.Accept()
+		if nil != err {
+			if IsErrClosing(err) {
+				return nil
+			}
+			if te, ok := err.(interface{ Temporary() bool }); ok && te.Temporary() {
+				if 0 == delay {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; time.Second < delay {
+					delay = time.Second
+				}
+				logln("accepting", err, "; retrying in", delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go handle(c)
 	}
-	return "use of closed network connection" == err.Error()
+}
+
+// Feed a signal to Wait as though it had been received, blocking until Wait
+// receives it, so Wait can be tested without signaling the whole process.
+func InjectSignal(sig os.Signal) {
+	injected <- sig
 }
 
 // Kill process specified in the environment with the signal specified in the
-// environment; default to SIGQUIT.
+// environment; default to SIGQUIT.  PreReady and then Register are called
+// first so that during a restart this process is fully set up and registered
+// before the other lets go.  A child whose parent is no longer its parent
+// returns ErrParentMismatch rather than signal a process that may not be it,
+// unless OrphanPolicy is OrphanTakeOver.
 func Kill() error {
+	if nil != conf().PreReady {
+		if err := conf().PreReady(); nil != err {
+			return err
+		}
+	}
+	if nil != conf().Register {
+		if err := conf().Register(); nil != err {
+			return err
+		}
+	}
+	if err := writeHandshake(); nil != err {
+		return err
+	}
+	if err := writeVersion(); nil != err {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
 	var (
 		pid int
 		sig syscall.Signal
 	)
-	_, err := fmt.Sscan(os.Getenv("GOAGAIN_PID"), &pid)
-	if io.EOF == err {
-		_, err = fmt.Sscan(os.Getenv("GOAGAIN_PPID"), &pid)
+	_, err := fmt.Sscan(os.Getenv(envKey("PID")), &pid)
+	inherited := io.EOF == err
+	if inherited && "" == os.Getenv(envKey("PPID")) && InPlace == conf().Strategy {
+		return nil
+	}
+	if inherited {
+		_, err = fmt.Sscan(os.Getenv(envKey("PPID")), &pid)
 	}
 	if nil != err {
 		return err
 	}
-	if _, err := fmt.Sscan(os.Getenv("GOAGAIN_SIGNAL"), &sig); nil != err {
+	if inherited && syscall.Getppid() != pid {
+		if OrphanTakeOver != conf().OrphanPolicy {
+			return ErrParentMismatch
+		}
+		recordSpawnedEnv()
+		letGo = true
+		event(
+			"orphan-takeover",
+			[]interface{}{"parent", pid},
+			"taking over from process", pid, "which already died",
+		)
+		return nil
+	}
+	if _, err := fmt.Sscan(os.Getenv(envKey("SIGNAL")), &sig); nil != err {
 		sig = syscall.SIGQUIT
 	}
-	if syscall.SIGQUIT == sig && Double == Strategy {
-		go syscall.Wait4(pid, nil, 0, nil)
+	if inherited && Double != conf().Strategy {
+		recordSpawnedEnv()
+	}
+	if inherited && Double == conf().Strategy {
+		yielding = true
+	}
+	if syscall.SIGQUIT == sig && Double == conf().Strategy {
+		go reap(pid)
+	}
+	event(
+		"kill",
+		[]interface{}{"signal", int(sig), "pid", pid},
+		"sending signal", sig, "to process", pid,
+	)
+	if err := kill(pid, sig); nil != err {
+		return err
 	}
-	log.Println("sending signal", sig, "to process", pid)
-	return syscall.Kill(pid, sig)
+	letGo = true
+	return nil
 }
 
 // Reconstruct a net.Listener from a file descriptior and name specified in the
-// environment.  Deal with Go's insistence on dup(2)ing file descriptors.
+// environment.  Deal with Go's insistence on dup(2)ing file descriptors.  A
+// socket with a pending error, as might be left behind by a parent that
+// crashed partway through shutting down, is closed and rejected so the caller
+// can fall back to listening anew.  Under SocketHandoff, receive the first
+// listener handed off instead.
 func Listener() (l net.Listener, err error) {
+	if path := getenv(envKey("SOCKET")); "" != path {
+		ls, err := socketListeners(path)
+		if nil != err {
+			return nil, err
+		}
+		if 0 == len(ls) {
+			return nil, ErrNotInherited
+		}
+		return ls[0], nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if "" == os.Getenv(envKey("FD")) {
+		return nil, ErrNotInherited
+	}
 	var fd uintptr
-	if _, err = fmt.Sscan(os.Getenv("GOAGAIN_FD"), &fd); nil != err {
+	if _, err = fmt.Sscan(os.Getenv(envKey("FD")), &fd); nil != err {
 		return
 	}
-	l, err = net.FileListener(os.NewFile(fd, os.Getenv("GOAGAIN_NAME")))
-	if nil != err {
-		return
+	return fileListener(fd, nameUnescaper.Replace(os.Getenv(envKey("NAME"))))
+}
+
+// Reconstruct every listener handed down by the parent process from the file
+// descriptors and names in the GOAGAIN_FDS and GOAGAIN_NAMES environment
+// variables, keyed by address, or received under SocketHandoff.  Use this or
+// Listener but not both.
+func Listeners() (map[string]net.Listener, error) {
+	if path := getenv(envKey("SOCKET")); "" != path {
+		sls, err := socketListeners(path)
+		if nil != err {
+			return nil, err
+		}
+		ls := make(map[string]net.Listener, len(sls))
+		for _, l := range sls {
+			ls[l.Addr().String()] = l
+		}
+		return ls, nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if "" == os.Getenv(envKey("FDS")) {
+		return nil, ErrNotInherited
 	}
-	switch l.(type) {
-	case *net.TCPListener, *net.UnixListener:
-	default:
-		err = fmt.Errorf(
-			"file descriptor is %T not *net.TCPListener or *net.UnixListener",
-			l,
+	fds := strings.Split(os.Getenv(envKey("FDS")), ",")
+	names := strings.Split(os.Getenv(envKey("NAMES")), ",")
+	if len(fds) != len(names) {
+		return nil, fmt.Errorf(
+			"%d file descriptors but %d names inherited",
+			len(fds),
+			len(names),
 		)
-		return
 	}
-	if err = syscall.Close(int(fd)); nil != err {
-		return
+	ls := make(map[string]net.Listener, len(fds))
+	for i := range fds {
+		var fd uintptr
+		if _, err := fmt.Sscan(fds[i], &fd); nil != err {
+			return nil, err
+		}
+		l, err := fileListener(fd, nameUnescaper.Replace(names[i]))
+		if nil != err {
+			return nil, err
+		}
+		ls[l.Addr().String()] = l
 	}
-	return
+	return ls, nil
+}
+
+// Take over the restart protocol for a net.Listener the caller created
+// itself, either freshly or by way of Listener.  If this process inherited
+// its listener, complete the handoff by signaling the other process.  Then
+// block awaiting signals and return the one that ended the wait so the caller
+// can shut down gracefully (and, given SIGUSR2 under the Double strategy,
+// call Exec).  goagain never binds a socket on the caller's behalf here.
+func Manage(l net.Listener) (syscall.Signal, error) {
+	if "" != getenv(envKey("FD")) || "" != getenv(envKey("SOCKET")) ||
+		ReusePort == conf().Strategy && "" != getenv(envKey("PPID")) {
+		if err := Kill(); nil != err {
+			return 0, err
+		}
+	}
+	return Wait(l)
 }
 
 // Block this goroutine awaiting signals.  Signals are handled as they
 // are by Nginx and Unicorn: <http://unicorn.bogomips.org/SIGNALS.html>.
-func Wait(l net.Listener) (syscall.Signal, error) {
+// The given listeners are the ones handed to ForkExec on SIGUSR2 and passed
+// to the hooks; there may be none if every listener belongs to a Manager.
+func Wait(ls ...net.Listener) (syscall.Signal, error) {
+	return WaitContext(context.Background(), ls...)
+}
+
+// Block this goroutine awaiting signals as Wait does until the context is
+// done, in which case stop handling signals and return its error.  Once a
+// signal ends the wait, GraceDeadline, if set, starts counting down.
+func WaitContext(ctx context.Context, ls ...net.Listener) (syscall.Signal, error) {
+	sig, err := waitContext(ctx, ls)
+	if 0 != sig {
+		if sigUSR2 != sig {
+			notifyStopping()
+		}
+		mu.Lock()
+		h := handedOff
+		mu.Unlock()
+		if h {
+			setRole("old, draining")
+		} else if sigUSR2 != sig {
+			setRole("shutting down")
+		}
+		beginShutdown()
+		armGraceDeadline()
+	}
+	return sig, err
+}
+
+func waitContext(ctx context.Context, ls []net.Listener) (syscall.Signal, error) {
+	mu.Lock()
+	y := yielding
+	mu.Unlock()
+	if !y {
+		if err := writePIDFile(); nil != err {
+			return 0, err
+		}
+		notifyReady()
+		setRole(servingRole())
+	}
 	ch := make(chan os.Signal, 2)
-	signal.Notify(
+	notifySignals(
 		ch,
 		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGQUIT,
 		syscall.SIGTERM,
-		syscall.SIGUSR1,
-		syscall.SIGUSR2,
+		sigUSR1,
+		sigUSR2,
 	)
-	forked := false
+	if 0 != conf().ReadySignal {
+		notifySignals(ch, conf().ReadySignal)
+	}
+	for sig := range conf().SignalMap {
+		notifySignals(ch, sig)
+	}
+	var deadline <-chan time.Time
 	for {
-		sig := <-ch
-		log.Println(sig.String())
+		var (
+			sig     os.Signal
+			restart bool
+		)
+		select {
+		case sig = <-ch:
+		case sig = <-injected:
+		case req := <-restarts:
+			if !startRestart(req) {
+				continue
+			}
+			sig, restart = sigUSR2, true
+		case <-ctx.Done():
+			stopSignals(ch)
+			return 0, ctx.Err()
+		case <-deadline:
+			deadline = nil
+			if p := getChild(); nil != p {
+				logln("child never became ready")
+				finishRestart(&RelaunchError{
+					Phase: PhaseReady,
+					PID:   p.Pid,
+					Err:   fmt.Errorf("%w after %v", ErrHandoffTimeout, conf().ReadyTimeout),
+				})
+				if err := CancelRelaunch(); nil != err {
+					logln(err)
+				}
+			}
+			continue
+		}
+		event("signal", []interface{}{"signal", fmt.Sprintf("%d", sig)}, sig.String())
+
+		// ReadySignal from a child in flight means it's taking over.
+		if 0 != conf().ReadySignal && conf().ReadySignal == sig && nil != getChild() {
+			if versionRefused() || deferHandoff(sig) || !parentExit() {
+				continue
+			}
+			preHandoff(ls)
+			if Double == conf().Strategy {
+				recordSpawned()
+				return sigUSR2, nil
+			}
+			setChild(nil)
+			return syscall.SIGQUIT, nil
+		}
+		if s, ok := sig.(syscall.Signal); ok && !restart && !(readySignal() == s && nil != getChild()) {
+			if to, ok := conf().SignalMap[s]; ok {
+				if 0 == to {
+					continue
+				}
+				sig = to
+			}
+		}
+
 		switch sig {
 
 		// SIGHUP should reload configuration.
 		case syscall.SIGHUP:
-			if nil != OnSIGHUP {
-				if err := OnSIGHUP(l); nil != err {
-					log.Println("OnSIGHUP:", err)
-				}
-			}
+			callHooks("OnSIGHUP", ls, conf().OnSIGHUP, func(m *Manager) hook {
+				return m.OnSIGHUP
+			})
+			reload()
 
 		// SIGINT should exit.
 		case syscall.SIGINT:
+			deregister()
 			return syscall.SIGINT, nil
 
-		// SIGQUIT should exit gracefully.
+		// SIGQUIT should exit gracefully.  If a child was in flight, it has
+		// now taken over.
 		case syscall.SIGQUIT:
+			if nil != getChild() {
+				if versionRefused() || deferHandoff(sig) || !parentExit() {
+					continue
+				}
+				preHandoff(ls)
+			} else {
+				deregister()
+			}
+			setChild(nil)
 			return syscall.SIGQUIT, nil
 
 		// SIGTERM should exit.
 		case syscall.SIGTERM:
+			deregister()
 			return syscall.SIGTERM, nil
 
 		// SIGUSR1 should reopen logs.
-		case syscall.SIGUSR1:
-			if nil != OnSIGUSR1 {
-				if err := OnSIGUSR1(l); nil != err {
-					log.Println("OnSIGUSR1:", err)
+		case sigUSR1:
+			callHooks("OnSIGUSR1", ls, conf().OnSIGUSR1, func(m *Manager) hook {
+				return m.OnSIGUSR1
+			})
+			reopenLogs()
+
+		// SIGUSR2 forks and re-execs the first time it is received and,
+		// under the Double strategy, execs without forking while that child
+		// is in flight.  Otherwise a second SIGUSR2 while a child is in
+		// flight, as from an impatient operator, is coalesced with the
+		// first rather than spawn a second child to race it.  Supervised
+		// processes leave restarting to their supervisor and InPlace
+		// processes to the caller, as do processes that can't hand their
+		// listeners to a child, on Windows, except by SO_REUSEADDR.
+		case sigUSR2:
+			if Supervised == conf().Strategy || InPlace == conf().Strategy || !canInherit && ReusePort != conf().Strategy {
+				finishRestart(nil)
+				deregister()
+				return sigUSR2, nil
+			}
+			if p := getChild(); nil != p {
+				if sigUSR2 != readySignal() {
+					event(
+						"coalesce",
+						[]interface{}{"child", p.Pid},
+						"restart already in progress with child", p.Pid,
+					)
+					continue
+				}
+				if versionRefused() || deferHandoff(sig) || !parentExit() {
+					continue
+				}
+				if Double == conf().Strategy {
+					recordSpawned()
 				}
+				preHandoff(ls)
+				return sigUSR2, nil
 			}
-
-		// SIGUSR2 forks and re-execs the first time it is received and execs
-		// without forking from then on.
-		case syscall.SIGUSR2:
-			if forked {
-				return syscall.SIGUSR2, nil
+			if 0 != conf().RestartWhenIdle {
+				logln("waiting for", ActiveConnections(), "connections")
+				if err := WaitForConnections(conf().RestartWhenIdle); nil != err {
+					logln("restarting anyway:", err)
+				}
 			}
-			forked = true
-			if err := ForkExec(l); nil != err {
-				return syscall.SIGUSR2, err
+			if err := ForkExec(ls...); nil != err {
+				finishRestart(&RelaunchError{Phase: PhaseSpawn, Err: err})
+				if errors.Is(err, ErrThrottled) {
+					logln(err)
+					continue
+				}
+				return sigUSR2, err
+			}
+			if 0 != conf().ReadyTimeout {
+				deadline = time.After(conf().ReadyTimeout)
 			}
 
 		}
 	}
 }
 
-func lookPath() (argv0 string, err error) {
-	argv0, err = exec.LookPath(os.Args[0])
+func dupConn(sc syscall.Conn) (fd int, err error) {
+	rc, err := sc.SyscallConn()
 	if nil != err {
-		return
+		return -1, err
 	}
-	if _, err = os.Stat(argv0); nil != err {
-		return
+	var derr error
+	if err = rc.Control(func(sysfd uintptr) {
+		fd, derr = dup(int(sysfd))
+	}); nil != err {
+		return -1, err
+	}
+	return fd, derr
+}
+
+func fileName(l net.Listener) string {
+	addr := l.Addr()
+	return fmt.Sprintf("%s:%s->", addr.Network(), addr.String())
+}
+
+// Escape and unescape names given by fileName for the environment, where
+// they're separated by commas and can't contain NUL bytes, both of which an
+// abstract Unix socket's name may.
+var (
+	nameEscaper   = strings.NewReplacer("%", "%25", ",", "%2C", "\x00", "%00")
+	nameUnescaper = strings.NewReplacer("%25", "%", "%2C", ",", "%00", "\x00")
+)
+
+func getChild() *os.Process {
+	mu.Lock()
+	defer mu.Unlock()
+	return child
+}
+
+func getenv(key string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return os.Getenv(key)
+}
+
+// Return Dir or, by default, this process' working directory.
+func workDir() (string, error) {
+	if "" != conf().Dir {
+		return conf().Dir, nil
+	}
+	return os.Getwd()
+}
+
+// Return Args or, by default, os.Args.
+func argv() []string {
+	if nil != conf().Args {
+		return conf().Args
+	}
+	return os.Args
+}
+
+// Return the program to run and its arguments: those given to RestartCommand,
+// just once, or else lookPath's and argv's.  Call with mu held.
+func command() (string, []string, error) {
+	if args := restartCommand; nil != args {
+		restartCommand = nil
+		argv0, err := exec.LookPath(args[0])
+		return argv0, args, err
+	}
+	argv0, err := lookPath()
+	return argv0, argv(), err
+}
+
+// Find the program to run: Executable or else the first of Args or os.Args,
+// looked up in PATH.  If that's gone, fall back to this very program by way
+// of /proc/self/exe where there is such a thing, which finds it even if it's
+// been renamed or deleted, or else to the path os.Executable reports, as on
+// macOS and BSD.
+func lookPath() (argv0 string, err error) {
+	name := argv()[0]
+	if "" != conf().Executable {
+		name = conf().Executable
+	}
+	argv0, err = exec.LookPath(name)
+	if nil == err {
+		_, err = os.Stat(argv0)
+	}
+	if nil != err && "" == conf().Executable && nil == conf().Args {
+		if _, serr := os.Stat("/proc/self/exe"); nil == serr {
+			logln("restarting /proc/self/exe since", err)
+			return "/proc/self/exe", nil
+		}
+		if exe, eerr := os.Executable(); nil == eerr {
+			if _, serr := os.Stat(exe); nil == serr {
+				logln("restarting", exe, "since", err)
+				return exe, nil
+			}
+		}
 	}
 	return
 }
 
-func setEnvs(l net.Listener) (fd uintptr, err error) {
-	var f *os.File
+// Duplicate the file descriptor underlying any listener that has one: those
+// that implement syscall.Conn like *net.TCPListener and *net.UnixListener,
+// those with a File method, and wrappers like TrackingListener that reveal
+// what they wrap with an Unwrap method.  syscall.Conn is preferred since File
+// leaves the listener in blocking mode once its Fd method is called.
+func listenerFD(l net.Listener) (int, error) {
 	switch t := l.(type) {
-	case *net.TCPListener:
-		f, err = t.File()
-	case *net.UnixListener:
-		f, err = t.File()
-	default:
-		return fd, fmt.Errorf("setEnvs: file descriptor is %T not *net.TCPListener or *net.UnixListener", l)
-	}
-	if err != nil {
+	case syscall.Conn:
+		return dupConn(t)
+	case interface {
+		File() (*os.File, error)
+	}:
+		f, err := t.File()
+		if nil != err {
+			return -1, err
+		}
+		defer f.Close()
+		return dup(int(f.Fd()))
+	case interface {
+		Unwrap() net.Listener
+	}:
+		return listenerFD(t.Unwrap())
+	}
+	return -1, fmt.Errorf("%T has no file descriptor", l)
+}
+
+// Return the standard streams for a new process and a function that closes
+// those opened for it by ChildStreams.
+func childStreams() (std [3]*os.File, done func(), err error) {
+	var opened [3]*os.File
+	if nil != conf().ChildStreams {
+		if opened[0], opened[1], opened[2], err = conf().ChildStreams(); nil != err {
+			return
+		}
+	}
+	own := [3]*os.File{os.Stdin, os.Stdout, os.Stderr}
+	for i, f := range [3]*os.File{conf().ChildStdin, conf().ChildStdout, conf().ChildStderr} {
+		std[i] = orFile(opened[i], orFile(f, own[i]))
+	}
+	done = func() {
+		for i, f := range opened {
+			if nil != f && f != own[i] {
+				f.Close()
+			}
+		}
+	}
+	return
+}
+
+func orFile(f, dflt *os.File) *os.File {
+	if nil == f {
+		return dflt
+	}
+	return f
+}
+
+// Call OnParentExit now that the child is ready to take over and report
+// whether the handoff may proceed, killing the child if not.
+func parentExit() bool {
+	if nil == conf().OnParentExit {
+		return true
+	}
+	if err := conf().OnParentExit(); nil != err {
+		hookError("OnParentExit", err)
+		CancelRelaunch()
+		return false
+	}
+	return true
+}
+
+// Check the build of the child that's ready to take over, as ProtocolVersion
+// and Compatible say to, and report whether it's refused, killing it if so.
+func versionRefused() bool {
+	p := getChild()
+	if nil == p {
+		return false
+	}
+	err := checkVersion(p.Pid)
+	if nil == err {
+		return false
+	}
+	logln(err)
+	finishRestart(&RelaunchError{Phase: PhaseReady, PID: p.Pid, Err: err})
+	CancelRelaunch()
+	return true
+}
+
+// Call the PreHandoff hooks now that the child is taking over and report
+// the Restart in progress, if any, a success.
+func preHandoff(ls []net.Listener) {
+	if p := getChild(); nil != p {
+		notifyHandoff(p.Pid)
+	}
+	keepSocketFiles(ls)
+	callHooks("PreHandoff", ls, conf().PreHandoff, func(m *Manager) hook {
+		return m.PreHandoff
+	})
+	upgradeSucceeded()
+	finishRestart(nil)
+}
+
+func readySignal() syscall.Signal {
+	if 0 != conf().ReadySignal {
+		return conf().ReadySignal
+	}
+	if Double == conf().Strategy {
+		return sigUSR2
+	}
+	return syscall.SIGQUIT
+}
+
+// Reap the child process spawned by ForkExec.  If it exits before taking
+// over, as it might given a bad configuration or a broken deploy, forget it
+// so this process carries on serving and may relaunch again.
+func supervise(p *os.Process, done chan struct{}) {
+	defer close(done)
+	state, err := p.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if child != p {
 		return
 	}
-	fd = f.Fd()
-	if err = os.Setenv("GOAGAIN_FD", fmt.Sprint(fd)); nil != err {
+	child = nil
+	restartFailed()
+	forgetChild()
+	if nil != err {
+		logln("waiting for child", p.Pid, err)
+		finishRestartLocked(&RelaunchError{Phase: PhaseReady, PID: p.Pid, Err: err})
 		return
 	}
-	addr := l.Addr()
-	if err = os.Setenv(
-		"GOAGAIN_NAME",
-		fmt.Sprintf("%s:%s->", addr.Network(), addr.String()),
-	); nil != err {
+	finishRestartLocked(&RelaunchError{
+		Phase: PhaseReady,
+		PID:   p.Pid,
+		Err:   &ChildExitError{PID: p.Pid, ExitCode: state.ExitCode()},
+	})
+	event(
+		"exit",
+		[]interface{}{"child", p.Pid, "status", state.ExitCode()},
+		"child", p.Pid, "exited before taking over:", state,
+	)
+}
+
+// Close what was kept open for a child that's no longer taking over: the
+// pipe it was to report its build over and the sockets over which
+// connections were to migrate and QUIC packets to be routed.  Call with mu
+// held.
+func forgetChild() {
+	if nil != versionPipe {
+		versionPipe.Close()
+		versionPipe = nil
+	}
+	endMigration()
+	for _, qc := range quicConns {
+		qc.setChild(nil)
+	}
+}
+
+func setChild(p *os.Process) {
+	mu.Lock()
+	defer mu.Unlock()
+	child = p
+}
+
+// Set the GOAGAIN_* environment variables for the new process that describe
+// the given listeners, every Manager's listeners, every datagram socket added
+// with AddPacketConn, and every other file to be handed to it and return the
+// duplicate file descriptors to hand it, which are close-on-exec.  Call with
+// mu held.
+func setEnvs(v envVars, ls []net.Listener) (fds []int, err error) {
+	defer func() {
+		if nil != err {
+			closeFDs(fds)
+			fds = nil
+		}
+	}()
+	v.set("SOCKET", "")
+	manifest := make(map[string]uintptr)
+	var lfds, names []string
+	for _, l := range ls {
+		if nil == l {
+			continue
+		}
+		var fd int
+		if fd, err = listenerFD(l); nil != err {
+			return
+		}
+		fds = append(fds, fd)
+		if 0 == len(lfds) {
+			manifest["listener"] = uintptr(fd)
+		} else {
+			manifest[fmt.Sprintf("listener/%d", len(lfds))] = uintptr(fd)
+		}
+		lfds = append(lfds, fmt.Sprint(fd))
+		names = append(names, nameEscaper.Replace(fileName(l)))
+	}
+
+	// GOAGAIN_FD and GOAGAIN_NAME describe the first listener, for
+	// programs that expect only one.
+	if 0 == len(lfds) {
+		v.set("FD", "")
+		v.set("NAME", "")
+	} else {
+		v.set("FD", lfds[0])
+		v.set("NAME", names[0])
+	}
+	v.set("FDS", strings.Join(lfds, ","))
+	v.set("NAMES", strings.Join(names, ","))
+	for _, m := range managers {
+		for i, ml := range m.listeners {
+			var fd int
+			if fd, err = listenerFD(ml); nil != err {
+				return
+			}
+			fds = append(fds, fd)
+			manifest[fmt.Sprintf("%s/%d", m.name, i)] = uintptr(fd)
+		}
+	}
+	for i, c := range packetConns {
+		var fd int
+		if fd, err = packetConnFD(c); nil != err {
+			return
+		}
+		fds = append(fds, fd)
+		manifest[fmt.Sprintf("packet/%d", i)] = uintptr(fd)
+	}
+	for purpose, f := range extraFiles {
+		var fd int
+		if fd, err = dupConn(f); nil != err {
+			return
+		}
+		fds = append(fds, fd)
+		manifest[purpose] = uintptr(fd)
+	}
+	v.set("INHERIT", fmt.Sprint(InheritProtocol))
+	v.set("MANIFEST", formatManifest(manifest))
+	v.set("STATS", formatStats())
+	if err = setPriorityEnv(v); nil != err {
 		return
 	}
+	setGenerationEnv(v)
+	v.set("SOCKOPTS", formatSocketOptions())
 	return
 }
@@ -0,0 +1,160 @@
+//go:build !windows
+
+package goagain
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCancelRelaunch(t *testing.T) {
+	l := testListener(t)
+	spawnTestChild(t, "hang")
+	pv := ProtocolVersion
+	ProtocolVersion = 1
+	t.Cleanup(func() { ProtocolVersion = pv })
+
+	if err := ForkExec(l); nil != err {
+		t.Fatal(err)
+	}
+	p := getChild()
+	if nil == p {
+		t.Fatal("no child in flight after ForkExec")
+	}
+	mu.Lock()
+	vp := versionPipe
+	mu.Unlock()
+	if nil == vp {
+		t.Fatal("no pipe open for the child to report its version over")
+	}
+
+	if err := CancelRelaunch(); nil != err {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(p.Pid, 0); syscall.ESRCH != err {
+		t.Errorf("child %d survived CancelRelaunch: %v", p.Pid, err)
+	}
+	if nil != getChild() {
+		t.Error("child still in flight after CancelRelaunch")
+	}
+	mu.Lock()
+	vp = versionPipe
+	mu.Unlock()
+	if nil != vp {
+		t.Error("version pipe left open after CancelRelaunch")
+	}
+	if err := CancelRelaunch(); ErrNoRelaunch != err {
+		t.Errorf("second CancelRelaunch returned %v, not ErrNoRelaunch", err)
+	}
+	testAccept(t, l)
+}
+
+func TestManage(t *testing.T) {
+	resetAfter(t)
+	l := testListener(t)
+	ch := manageAsync(t, l)
+	InjectSignal(syscall.SIGTERM)
+	if sig := receiveSignal(t, ch); syscall.SIGTERM != sig {
+		t.Errorf("Manage returned %v, not SIGTERM", sig)
+	}
+}
+
+// Restart, handing the listener to a child in "serve" mode, which is stopped
+// once the test ends.
+func testHandoff(t *testing.T, l net.Listener) {
+	t.Helper()
+	resetAfter(t)
+	spawnTestChild(t, "serve")
+	ch := manageAsync(t, l)
+	InjectSignal(SIGUSR2)
+	if sig := receiveSignal(t, ch); syscall.SIGQUIT != sig {
+		t.Fatalf("Manage returned %v, not SIGQUIT", sig)
+	}
+	stopChildAfter(t)
+}
+
+func TestManageHandoff(t *testing.T) {
+	l := testListener(t)
+	testHandoff(t, l)
+	addr := l.Addr().String()
+	l.Close()
+	if pid := dialPID(t, addr); os.Getpid() == pid {
+		t.Error("the caller's listener wasn't handed to the child")
+	}
+}
+
+func TestChildStreams(t *testing.T) {
+	resetAfter(t)
+	l := testListener(t)
+	spawnTestChild(t, "print")
+	dir := t.TempDir()
+	out, err := os.Create(filepath.Join(dir, "stdout"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	opened := 0
+	stdout, stderr, streams := ChildStdout, ChildStderr, ChildStreams
+	ChildStdout = out
+	ChildStreams = func() (stdin, stdout, stderr *os.File, err error) {
+		opened++
+		stderr, err = os.Create(filepath.Join(dir, "stderr"))
+		return
+	}
+	t.Cleanup(func() { ChildStdout, ChildStderr, ChildStreams = stdout, stderr, streams })
+
+	if err := ForkExec(l); nil != err {
+		t.Fatal(err)
+	}
+	waitChild(t)
+	if 1 != opened {
+		t.Errorf("ChildStreams called %d times", opened)
+	}
+	for name, want := range map[string]string{"stdout": "stdout", "stderr": "stderr"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if nil != err {
+			t.Fatal(err)
+		}
+		if want != string(b) {
+			t.Errorf("child wrote %q to %s, not %q", b, name, want)
+		}
+	}
+}
+
+func TestPreHandoff(t *testing.T) {
+	l := testListener(t)
+	var called []net.Listener
+	preHandoff := PreHandoff
+	PreHandoff = func(hl net.Listener) error {
+		called = append(called, hl)
+		return nil
+	}
+	t.Cleanup(func() { PreHandoff = preHandoff })
+	testHandoff(t, l)
+	if 1 != len(called) || l != called[0] {
+		t.Errorf("PreHandoff called with %v, not once with %v", called, l)
+	}
+}
+
+// Under the Supervised strategy, SIGUSR2 ends the wait for the supervisor to
+// restart the process rather than spawn a child.
+func TestSupervised(t *testing.T) {
+	resetAfter(t)
+	l := testListener(t)
+	spawnTestChild(t, "hang")
+	strategy := Strategy
+	Strategy = Supervised
+	t.Cleanup(func() { Strategy = strategy })
+	ch := manageAsync(t, l)
+	InjectSignal(SIGUSR2)
+	if sig := receiveSignal(t, ch); SIGUSR2 != sig {
+		t.Errorf("Manage returned %v, not SIGUSR2", sig)
+	}
+	if p := getChild(); nil != p {
+		p.Kill()
+		t.Error("spawned a child under the Supervised strategy")
+	}
+}
@@ -0,0 +1,285 @@
+// Test the restart flow of a program that uses goagain end to end, as in CI:
+// run the program, restart it, and check that its listener stayed bound and
+// every connection made meanwhile succeeded.
+//
+//	s := &goagaintest.Server{Path: "./myserver", Addr: "127.0.0.1:8080"}
+//	if err := s.Start(); nil != err {
+//		t.Fatal(err)
+//	}
+//	defer s.Stop()
+//	if _, err := s.Upgrade(); nil != err {
+//		t.Fatal(err)
+//	}
+//
+// To have a child fail on purpose, the program calls MaybeFail first thing in
+// main and the test calls InjectFailure before Upgrade.
+package goagaintest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rcrowley/goagain"
+)
+
+// The ways InjectFailure can make the next child fail.
+const (
+	FailExit = "exit" // exit with status 1 before taking over
+	FailHang = "hang" // never take over
+)
+
+// Names the file in which the test tells the next child how to fail.
+const controlEnv = "GOAGAINTEST_CONTROL"
+
+// ErrConnectionFailed is returned by Upgrade when a connection made while
+// the child took over failed.
+var ErrConnectionFailed = errors.New("goagaintest: connection failed during upgrade")
+
+// A Server is a program that uses goagain, run and restarted by a test.  Set
+// at least Path and Addr before calling Start.
+type Server struct {
+
+	// Path and Args are the program and its arguments and Env, if not
+	// nil, its environment.
+	Path string
+	Args []string
+	Env  []string
+
+	// Network and Addr are where the program listens, "tcp" by default.
+	Network, Addr string
+
+	// Stdout and Stderr receive the output of every generation.
+	Stdout, Stderr io.Writer
+
+	// Timeout bounds Start, Upgrade, and Stop, ten seconds by default.
+	Timeout time.Duration
+
+	// Probe, if not nil, is called with every connection Upgrade makes
+	// while the child takes over, to send a request and check the
+	// response.  By default connections are only made and closed.
+	Probe func(c net.Conn) error
+
+	dir    string
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+// The outcome of an Upgrade.
+type Result struct {
+	OldPID, NewPID int
+	Connections    int     // made while the child took over
+	Failures       []error // of those connections
+}
+
+// Start the program with GOAGAIN_PIDFILE set, to follow it through restarts,
+// and wait until it's serving.  The program mustn't change goagain's
+// environment prefix.
+func (s *Server) Start() error {
+	dir, err := os.MkdirTemp("", "goagaintest")
+	if nil != err {
+		return err
+	}
+	s.dir = dir
+	env := s.Env
+	if nil == env {
+		env = os.Environ()
+	}
+	s.cmd = exec.Command(s.Path, s.Args...)
+	s.cmd.Env = append(
+		env,
+		"GOAGAIN_PIDFILE="+s.pidFile(),
+		controlEnv+"="+s.controlFile(),
+	)
+	s.cmd.Stdout, s.cmd.Stderr = s.Stdout, s.Stderr
+	if err := s.cmd.Start(); nil != err {
+		os.RemoveAll(dir)
+		return err
+	}
+	s.exited = make(chan struct{})
+	go func() {
+		s.cmd.Wait()
+		close(s.exited)
+	}()
+	deadline := time.Now().Add(s.timeout())
+	for {
+		if pid, err := s.PID(); nil == err && pid == s.cmd.Process.Pid && nil == s.dial() {
+			return nil
+		}
+		select {
+		case <-s.exited:
+			return fmt.Errorf("goagaintest: %s exited before serving", s.Path)
+		default:
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("goagaintest: %s didn't serve within %v", s.Path, s.timeout())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Return the PID of the process serving, as it wrote to its PID file.
+func (s *Server) PID() (int, error) {
+	b, err := os.ReadFile(s.pidFile())
+	if nil != err {
+		return 0, err
+	}
+	return strconv.Atoi(string(bytes.TrimSpace(b)))
+}
+
+// Make the next child fail in the given way, FailExit or FailHang, or, given
+// the empty string, not.  The program must call MaybeFail.
+func (s *Server) InjectFailure(how string) error {
+	return os.WriteFile(s.controlFile(), []byte(how), 0644)
+}
+
+// Send SIGUSR2 and wait until another process is serving, making connections
+// all the while.  The error says whether the upgrade timed out or any of
+// those connections failed, matching ErrConnectionFailed in that case.  A
+// program under the Double strategy keeps its PID so can't be upgraded this
+// way.
+func (s *Server) Upgrade() (*Result, error) {
+	pid, err := s.PID()
+	if nil != err {
+		return nil, err
+	}
+	res := &Result{OldPID: pid}
+	stop, probed := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(probed)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			res.Connections++
+			if err := s.dial(); nil != err {
+				res.Failures = append(res.Failures, err)
+			}
+			time.Sleep(time.Millisecond) // Spare the ephemeral ports.
+		}
+	}()
+	err = s.upgrade(res)
+	close(stop)
+	<-probed
+	if nil != err {
+		return res, err
+	}
+	if 0 < len(res.Failures) {
+		return res, fmt.Errorf(
+			"%w: %d of %d, first: %v",
+			ErrConnectionFailed,
+			len(res.Failures),
+			res.Connections,
+			res.Failures[0],
+		)
+	}
+	return res, nil
+}
+
+func (s *Server) upgrade(res *Result) error {
+	if err := goagain.Signal(res.OldPID, goagain.SIGUSR2); nil != err {
+		return err
+	}
+	deadline := time.Now().Add(s.timeout())
+	for {
+		if pid, err := s.PID(); nil == err && pid != res.OldPID && nil == goagain.Signal(pid, 0) {
+			res.NewPID = pid
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf(
+				"goagaintest: process %d didn't hand off within %v",
+				res.OldPID,
+				s.timeout(),
+			)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Send SIGTERM to the process serving, wait for it to exit, and clean up.
+func (s *Server) Stop() error {
+	defer os.RemoveAll(s.dir)
+	pid, err := s.PID()
+	if nil != err {
+		return err
+	}
+	if err := goagain.Signal(pid, syscall.SIGTERM); nil != err {
+		return err
+	}
+	deadline := time.Now().Add(s.timeout())
+	for nil == goagain.Signal(pid, 0) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("goagaintest: process %d didn't exit within %v", pid, s.timeout())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func (s *Server) dial() error {
+	network := s.Network
+	if "" == network {
+		network = "tcp"
+	}
+	c, err := net.DialTimeout(network, s.Addr, s.timeout())
+	if nil != err {
+		return err
+	}
+	defer c.Close()
+	if nil != s.Probe {
+		return s.Probe(c)
+	}
+	return nil
+}
+
+func (s *Server) controlFile() string {
+	return filepath.Join(s.dir, "control")
+}
+
+func (s *Server) pidFile() string {
+	return filepath.Join(s.dir, "pid")
+}
+
+func (s *Server) timeout() time.Duration {
+	if 0 == s.Timeout {
+		return 10 * time.Second
+	}
+	return s.Timeout
+}
+
+var failOnce sync.Once
+
+// Fail as the test said to with InjectFailure, if this process is a child
+// run by a Server.  The failure is consumed so only one child fails.  Call
+// it first thing in main; it does nothing outside of a test.
+func MaybeFail() {
+	failOnce.Do(func() {
+		name := os.Getenv(controlEnv)
+		if "" == name || 0 == goagain.ParentPid() {
+			return
+		}
+		b, err := os.ReadFile(name)
+		if nil != err {
+			return
+		}
+		os.Remove(name)
+		switch string(bytes.TrimSpace(b)) {
+		case FailExit:
+			os.Exit(1)
+		case FailHang:
+			select {}
+		}
+	})
+}
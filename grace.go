@@ -0,0 +1,88 @@
+package goagain
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// These are read without synchronization so set them before calling Wait.
+var (
+	// GraceDeadline, if not zero, is the longest this process may live
+	// once a signal ends Wait, whether it's handing off to a child or
+	// shutting down.  If it's still running then, connections accepted by
+	// TrackingListeners are forcibly closed, as by CloseConnections, and
+	// it exits with status 1 so a single long-polling client can't keep
+	// an old binary alive forever.
+	GraceDeadline time.Duration
+
+	// OnForceClose, if not nil, is called with every connection about to
+	// be forcibly closed, to write a best-effort response (a 503 to an
+	// HTTP client waiting on a long poll, say) before it's closed.  It
+	// should set a write deadline on the connection so as not to block.
+	OnForceClose func(c net.Conn)
+)
+
+var graceOnce sync.Once
+
+// Closed once a signal has ended Wait and this process is shutting down.
+var (
+	shuttingDown = make(chan struct{})
+	shutdownOnce sync.Once
+)
+
+func beginShutdown() {
+	shutdownOnce.Do(func() {
+		statusShutdown()
+		close(shuttingDown)
+	})
+}
+
+// Forcibly close every connection accepted by a TrackingListener and still
+// active, after calling OnForceClose with each, and return how many there
+// were.
+func CloseConnections() int {
+	return closeTracked(nil)
+}
+
+// Start counting down GraceDeadline, once.
+func armGraceDeadline() {
+	if 0 == conf().GraceDeadline {
+		return
+	}
+	graceOnce.Do(func() {
+		time.AfterFunc(conf().GraceDeadline, func() {
+			n := CloseConnections()
+			event(
+				"grace-deadline",
+				[]interface{}{"connections", n},
+				"grace deadline passed; closed", n, "connections",
+			)
+			if err := RemovePIDFile(); nil != err {
+				logln("removing PID file", err)
+			}
+			os.Exit(1)
+		})
+	})
+}
+
+// Forcibly close the connections accepted by the given TrackingListener or,
+// if it's nil, by any.
+func closeTracked(tl *TrackingListener) int {
+	trackedMu.Lock()
+	var cs []*trackedConn
+	for c := range tracked {
+		if nil == tl || c.tl == tl {
+			cs = append(cs, c)
+		}
+	}
+	trackedMu.Unlock()
+	for _, c := range cs {
+		if nil != conf().OnForceClose {
+			conf().OnForceClose(c.Conn)
+		}
+		c.Close()
+	}
+	return len(cs)
+}
@@ -0,0 +1,131 @@
+package goagain
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// A ListenerGroup runs an accept loop for each of several listeners and
+// stops them together: closing the group, or any of its listeners failing,
+// closes every one, and Wait waits for every loop to return and every
+// connection accepted to be closed.
+type ListenerGroup struct {
+	ls       []*TrackingListener
+	wg       sync.WaitGroup
+	stopping chan struct{}
+	once     sync.Once
+	errMu    sync.Mutex
+	err      error
+}
+
+// Group the given listeners, each wrapped as by Track.
+func NewListenerGroup(ls ...net.Listener) *ListenerGroup {
+	g := &ListenerGroup{stopping: make(chan struct{})}
+	for _, l := range ls {
+		tl, ok := l.(*TrackingListener)
+		if !ok {
+			tl = Track(l)
+		}
+		g.ls = append(g.ls, tl)
+	}
+	return g
+}
+
+// Return the group's listeners, to pass to Wait, Exec, or ForkExec.
+func (g *ListenerGroup) Listeners() []net.Listener {
+	ls := make([]net.Listener, len(g.ls))
+	for i, tl := range g.ls {
+		ls[i] = tl
+	}
+	return ls
+}
+
+// Accept connections from every listener in the group, each in an AcceptLoop
+// of its own, until the group is closed.  Any listener closing or failing
+// closes the whole group, and its error, if any, is returned by Wait.
+func (g *ListenerGroup) Serve(handle func(c net.Conn)) {
+	for _, tl := range g.ls {
+		g.wg.Add(1)
+		go func(tl *TrackingListener) {
+			defer g.wg.Done()
+			err := AcceptLoop(tl, handle)
+			select {
+			case <-g.stopping:
+			default:
+				if nil != err {
+					g.setErr(err)
+				}
+				g.Close()
+			}
+		}(tl)
+	}
+}
+
+// Return a channel that's closed once the group is closing, so custom accept
+// loops can tell a graceful shutdown from a failure.
+func (g *ListenerGroup) Stopping() <-chan struct{} {
+	return g.stopping
+}
+
+// Stop accepting connections by closing every listener in the group, once,
+// and return the first error closing one.
+func (g *ListenerGroup) Close() error {
+	var first error
+	g.once.Do(func() {
+		close(g.stopping)
+		for _, tl := range g.ls {
+			if err := tl.Close(); nil != err && !IsErrClosing(err) && nil == first {
+				first = err
+			}
+		}
+	})
+	return first
+}
+
+// Block until every accept loop started by Serve has returned and every
+// connection accepted by the group has been closed, or until the timeout
+// elapses, returning ErrDrainTimeout.  A timeout of zero waits forever.
+// Otherwise return the error that closed the group, if any.
+func (g *ListenerGroup) Wait(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		for _, tl := range g.ls {
+			<-tl.c.idleChan()
+		}
+		close(done)
+	}()
+	var expired <-chan time.Time
+	if 0 != timeout {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case <-done:
+	case <-expired:
+		return ErrDrainTimeout
+	}
+	g.errMu.Lock()
+	defer g.errMu.Unlock()
+	return g.err
+}
+
+// Close the group and wait for it as Wait does.
+func (g *ListenerGroup) Drain(timeout time.Duration) error {
+	logln("draining", len(g.ls), "listeners")
+	defer drainStarted()()
+	if err := g.Close(); nil != err {
+		return err
+	}
+	return g.Wait(timeout)
+}
+
+func (g *ListenerGroup) setErr(err error) {
+	g.errMu.Lock()
+	defer g.errMu.Unlock()
+	if nil == g.err {
+		g.err = err
+	}
+}
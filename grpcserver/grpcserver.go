@@ -0,0 +1,117 @@
+// Zero-downtime restarts for gRPC servers.
+//
+// This package doesn't import gRPC: a *grpc.Server satisfies Server as is.
+//
+// GracefulStop waits for every RPC in flight to finish, including streams
+// that may never end on their own, so ListenAndServe calls Stop, which
+// cancels them, once the timeout passes.  A long-lived stream can return
+// sooner by watching for the shutdown itself:
+//
+//	func (s *service) Watch(req *pb.WatchRequest, stream pb.Svc_WatchServer) error {
+//		for {
+//			select {
+//			case ev := <-s.events:
+//				if err := stream.Send(ev); nil != err {
+//					return err
+//				}
+//			case <-s.stopping:
+//				return status.Error(codes.Unavailable, "restarting; reconnect")
+//			case <-stream.Context().Done():
+//				return stream.Context().Err()
+//			}
+//		}
+//	}
+//
+// where s.stopping is closed by the OnStop function passed to
+// ListenAndServe, so clients reconnect, to the new process, straight away.
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/rcrowley/goagain"
+)
+
+// Server is the part of *grpc.Server that ListenAndServe uses.
+type Server interface {
+	Serve(net.Listener) error
+	GracefulStop()
+	Stop()
+}
+
+// Serve srv on the listener inherited from the parent process, if any, or on
+// a fresh listener bound to addr, and take part in the goagain restart
+// protocol until signaled to stop.  onStop, if not nil, is then called so
+// long-lived streams can end themselves, and srv is stopped gracefully,
+// waiting up to the given timeout for RPCs in flight to finish before
+// cancelling those that remain.  Under the Double strategy SIGUSR2
+// re-executes this process once it's stopped.
+func ListenAndServe(srv Server, addr string, timeout time.Duration, onStop func()) error {
+	l, err := goagain.Listener()
+	inherited := nil == err
+	if !inherited {
+		if l, err = goagain.ListenConfig().Listen(context.Background(), "tcp", addr); nil != err {
+			return err
+		}
+	}
+
+	// Serve on a duplicate so stopping srv, which closes the listener it's
+	// serving on, leaves the original open for Exec.
+	sl, err := dupListener(l)
+	if nil != err {
+		return err
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(sl) }()
+
+	if inherited {
+		if err := goagain.Kill(); nil != err {
+			return err
+		}
+	}
+	sig, err := goagain.Wait(l)
+	if nil != err {
+		return err
+	}
+
+	if nil != onStop {
+		onStop()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		srv.Stop()
+		<-stopped
+	}
+	if err := <-errs; nil != err {
+		return err
+	}
+	if goagain.SIGUSR2 == sig && goagain.Double == goagain.Strategy {
+		return goagain.Exec(l)
+	}
+	return l.Close()
+}
+
+func dupListener(l net.Listener) (net.Listener, error) {
+	fl, ok := l.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("%T has no file descriptor", l)
+	}
+	f, err := fl.File()
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
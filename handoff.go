@@ -0,0 +1,212 @@
+//go:build !windows
+
+package goagain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// SocketHandoff, if true, makes ForkExec hand the listeners given to it to
+// the child over a private Unix socket, with SCM_RIGHTS, rather than as
+// inherited file descriptors numbered in the environment.  Listener,
+// Listeners, and Listen receive them transparently.  Managers' listeners,
+// files, and datagram sockets are inherited as usual.  Set it before calling
+// Wait.
+var SocketHandoff bool
+
+// The most file descriptors sent in one message, which is Linux's limit.
+const maxHandoffFDs = 253
+
+// The manifest sent alongside the file descriptors, naming each in turn.
+type handoffManifest struct {
+	Names []string `json:"names"`
+}
+
+// Listeners received over the socket named by GOAGAIN_SOCKET, received once.
+var (
+	socketLs   []net.Listener
+	socketErr  error
+	socketOnce sync.Once
+)
+
+// Serve the given listeners to every process that connects to a Unix socket
+// bound to the given path, which is created accessible only to this user,
+// until the returned io.Closer is closed.  This hands listeners to a process
+// that's already running, which receives them with ReceiveListeners.
+func ServeListeners(path string, ls ...net.Listener) (io.Closer, error) {
+	ul, err := listenHandoff(path)
+	if nil != err {
+		return nil, err
+	}
+	go func() {
+		for {
+			c, err := ul.AcceptUnix()
+			if nil != err {
+				if !IsErrClosing(err) {
+					logln("accepting handoff", err)
+				}
+				return
+			}
+			if err := sendListeners(c, ls); nil != err {
+				logln("handing off listeners", err)
+			}
+			c.Close()
+		}
+	}()
+	return ul, nil
+}
+
+// Connect to the Unix socket bound to the given path by ServeListeners or by
+// a parent process with SocketHandoff set and receive its listeners, in the
+// order they were given.
+func ReceiveListeners(path string) ([]net.Listener, error) {
+	c, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if nil != err {
+		return nil, err
+	}
+	defer c.Close()
+	buf, oob := make([]byte, 64*1024), make([]byte, syscall.CmsgSpace(maxHandoffFDs*4))
+	n, oobn, _, _, err := c.ReadMsgUnix(buf, oob)
+	if nil != err {
+		return nil, err
+	}
+	var fds []int
+	scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if nil != err {
+		return nil, err
+	}
+	for _, scm := range scms {
+		rights, err := syscall.ParseUnixRights(&scm)
+		if nil != err {
+			closeFDs(fds)
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	var m handoffManifest
+	if err := json.Unmarshal(buf[:n], &m); nil != err {
+		closeFDs(fds)
+		return nil, err
+	}
+	if len(fds) != len(m.Names) {
+		closeFDs(fds)
+		return nil, fmt.Errorf(
+			"%d file descriptors but %d names received",
+			len(fds),
+			len(m.Names),
+		)
+	}
+	ls := make([]net.Listener, 0, len(fds))
+	for i, fd := range fds {
+		syscall.CloseOnExec(fd)
+		l, err := fileListener(uintptr(fd), m.Names[i])
+		if nil != err {
+			closeFDs(fds[i+1:])
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// Bind a Unix socket to the given path that only this user may connect to.
+func listenHandoff(path string) (*net.UnixListener, error) {
+	ul, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if nil != err {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); nil != err {
+		ul.Close()
+		return nil, err
+	}
+	return ul, nil
+}
+
+// Serve the given listeners once over a Unix socket in a private temporary
+// directory for the child about to be spawned and return the socket's path.
+// The socket is removed once it's been served or the given channel is
+// closed, as it is once the child has exited.  Call with mu held.
+func serveHandoff(ls []net.Listener, done <-chan struct{}) (string, error) {
+	dir, err := os.MkdirTemp("", "goagain")
+	if nil != err {
+		return "", err
+	}
+	path := filepath.Join(dir, "handoff.sock")
+	ul, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if nil != err {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	go func() {
+		<-done
+		ul.Close()
+	}()
+	go func() {
+		defer os.RemoveAll(dir)
+		c, err := ul.AcceptUnix()
+		if nil != err {
+			if !IsErrClosing(err) {
+				logln("accepting handoff", err)
+			}
+			return
+		}
+		defer c.Close()
+		ul.Close()
+		if err := sendListeners(c, ls); nil != err {
+			logln("handing off listeners", err)
+		}
+	}()
+	return path, nil
+}
+
+// Send duplicates of the listeners' file descriptors and their names.
+func sendListeners(c *net.UnixConn, ls []net.Listener) error {
+	if len(ls) > maxHandoffFDs {
+		return fmt.Errorf("%d listeners is more than %d", len(ls), maxHandoffFDs)
+	}
+	var (
+		fds []int
+		m   handoffManifest
+	)
+	defer func() { closeFDs(fds) }()
+	for _, l := range ls {
+		if nil == l {
+			continue
+		}
+		fd, err := listenerFD(l)
+		if nil != err {
+			return err
+		}
+		fds = append(fds, fd)
+		m.Names = append(m.Names, fileName(l))
+	}
+	b, err := json.Marshal(m)
+	if nil != err {
+		return err
+	}
+	var oob []byte
+	if 0 < len(fds) {
+		oob = syscall.UnixRights(fds...)
+	}
+	_, _, err = c.WriteMsgUnix(b, oob, nil)
+	return err
+}
+
+// Receive the listeners handed off over the socket named by GOAGAIN_SOCKET,
+// the first time this is called.
+func socketListeners(path string) ([]net.Listener, error) {
+	socketOnce.Do(func() {
+		socketLs, socketErr = ReceiveListeners(path)
+	})
+	return socketLs, socketErr
+}
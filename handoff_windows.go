@@ -0,0 +1,27 @@
+package goagain
+
+import (
+	"io"
+	"net"
+)
+
+// SocketHandoff has no effect on Windows, which has no SCM_RIGHTS.
+var SocketHandoff bool
+
+// Return an error since Windows has no SCM_RIGHTS.
+func ServeListeners(path string, ls ...net.Listener) (io.Closer, error) {
+	return nil, errNoInherit
+}
+
+// Return an error since Windows has no SCM_RIGHTS.
+func ReceiveListeners(path string) ([]net.Listener, error) {
+	return nil, errNoInherit
+}
+
+func serveHandoff(ls []net.Listener, done <-chan struct{}) (string, error) {
+	return "", errNoInherit
+}
+
+func socketListeners(path string) ([]net.Listener, error) {
+	return nil, errNoInherit
+}
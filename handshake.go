@@ -0,0 +1,93 @@
+package goagain
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrHandshakeMismatch is returned (wrapped in a *RelaunchError) by
+// RelaunchPipeHandshake when the child reports the wrong PID or nonce.
+var ErrHandshakeMismatch = errors.New("goagain: handshake mismatch")
+
+// The nonce for the child about to be spawned to report, guarded by mu.
+var nonce string
+
+// Fork and exec a child as ForkExec does and wait for it to write its PID
+// and a nonce chosen by this process to an inherited pipe, which it does
+// from Kill once it's ready.  This confirms both that the child is ready and
+// that it's the child this process spawned.  If the child reports the wrong
+// PID or nonce or doesn't report within the timeout, it's killed and a
+// *RelaunchError is returned.
+func RelaunchPipeHandshake(l net.Listener, timeout time.Duration) (childPID int, err error) {
+	buf := make([]byte, 16)
+	if _, err = rand.Read(buf); nil != err {
+		return
+	}
+	want := hex.EncodeToString(buf)
+	r, w, err := os.Pipe()
+	if nil != err {
+		return
+	}
+	defer r.Close()
+	mu.Lock()
+	nonce = want
+	mu.Unlock()
+	addFile("handshake", w)
+	err = ForkExec(l)
+	removeFile("handshake")
+	mu.Lock()
+	nonce = ""
+	mu.Unlock()
+	w.Close()
+	if nil != err {
+		return 0, &RelaunchError{Phase: PhaseSpawn, Err: err}
+	}
+	if p := getChild(); nil != p {
+		childPID = p.Pid
+	}
+	fail := func(err error) (int, error) {
+		CancelRelaunch()
+		return 0, &RelaunchError{Phase: PhaseReady, PID: childPID, Err: err}
+	}
+	if err = r.SetReadDeadline(time.Now().Add(timeout)); nil != err {
+		return fail(err)
+	}
+	var (
+		pid int
+		s   string
+	)
+	if _, err = fmt.Fscan(bufio.NewReader(r), &pid, &s); nil != err {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = ErrHandoffTimeout
+		}
+		return fail(err)
+	}
+	if pid != childPID || s != want {
+		return fail(ErrHandshakeMismatch)
+	}
+	return childPID, nil
+}
+
+// Write this process' PID and the nonce from the environment to the pipe
+// inherited for the purpose, if there is one.
+func writeHandshake() error {
+	manifest, err := Manifest()
+	if nil != err {
+		return err
+	}
+	fd, ok := manifest["handshake"]
+	if !ok {
+		return nil
+	}
+	f := os.NewFile(fd, "handshake")
+	defer f.Close()
+	_, err = fmt.Fprintln(f, syscall.Getpid(), getenv(envKey("NONCE")))
+	return err
+}
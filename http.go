@@ -0,0 +1,51 @@
+package goagain
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Wrap an http.Handler so every request in flight is counted as active by
+// the drain counter.  WaitForConnections then waits for requests to finish
+// rather than for connections to close, which is more accurate when clients
+// hold idle keep-alive connections open.
+func InFlightTracker(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conns.incr()
+		defer conns.decr()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Wrap an http.Handler so that, once a signal has ended Wait and this process
+// is draining, every response says "Connection: close" and clients reconnect,
+// to the new process, rather than pin this one for the keep-alive timeout.
+// HTTP/2 connections are sent GOAWAY instead.  If retryAfter isn't zero,
+// those responses also carry a Retry-After header of that many seconds.
+func DrainKeepAlives(h http.Handler, retryAfter time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-shuttingDown:
+			w.Header().Set("Connection", "close")
+			if 0 < retryAfter {
+				w.Header().Set(
+					"Retry-After",
+					strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)),
+				)
+			}
+		default:
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Disable keep-alives on srv, which closes its idle connections, once a
+// signal has ended Wait and this process is draining, so clients reconnect
+// to the new process straight away rather than after the keep-alive timeout.
+func DisableKeepAlivesOnShutdown(srv *http.Server) {
+	go func() {
+		<-shuttingDown
+		srv.SetKeepAlivesEnabled(false)
+	}()
+}
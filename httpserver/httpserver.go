@@ -0,0 +1,82 @@
+// Zero-downtime restarts for net/http servers.
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rcrowley/goagain"
+)
+
+// Serve srv on the listener inherited from the parent process, if any, or on
+// a fresh listener bound to srv.Addr, and take part in the goagain restart
+// protocol until signaled to stop.  srv is then shut down, waiting up to the
+// given timeout for requests in flight to finish before closing every
+// connection forcibly.  Under the Double strategy SIGUSR2 re-executes this
+// process once it's shut down.
+func ListenAndServe(srv *http.Server, timeout time.Duration) error {
+	l, err := goagain.Listener()
+	inherited := nil == err
+	if !inherited {
+		addr := srv.Addr
+		if "" == addr {
+			addr = ":http"
+		}
+		if l, err = goagain.ListenConfig().Listen(context.Background(), "tcp", addr); nil != err {
+			return err
+		}
+	}
+
+	// Serve on a duplicate so srv.Shutdown, which closes the listener it's
+	// serving on, leaves the original open for Exec.
+	sl, err := dupListener(l)
+	if nil != err {
+		return err
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(sl) }()
+
+	if inherited {
+		if err := goagain.Kill(); nil != err {
+			return err
+		}
+	}
+	sig, err := goagain.Wait(l)
+	if nil != err {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); context.DeadlineExceeded == err {
+		srv.Close()
+	} else if nil != err {
+		return err
+	}
+	if err := <-errs; http.ErrServerClosed != err {
+		return err
+	}
+	if goagain.SIGUSR2 == sig && goagain.Double == goagain.Strategy {
+		return goagain.Exec(l)
+	}
+	return l.Close()
+}
+
+func dupListener(l net.Listener) (net.Listener, error) {
+	fl, ok := l.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("%T has no file descriptor", l)
+	}
+	f, err := fl.File()
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
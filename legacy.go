@@ -1,10 +1,13 @@
 package goagain
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"syscall"
+	"time"
 )
 
 // Block this goroutine awaiting signals.  Signals are handled as they
@@ -16,17 +19,128 @@ func AwaitSignals(l net.Listener) (err error) {
 
 // Convert and validate the GOAGAIN_FD, GOAGAIN_NAME, and GOAGAIN_PPID
 // environment variables.  If all three are present and in order, this
-// is a child process that may pick up where the parent left off.
+// is a child process that may pick up where the parent left off.  They're
+// then unset so subprocesses this process spawns don't inherit them.
 func GetEnvs() (l net.Listener, ppid int, err error) {
-	if _, err = fmt.Sscan(os.Getenv("GOAGAIN_PPID"), &ppid); nil != err {
+	if _, err = fmt.Sscan(getenv(envKey("PPID")), &ppid); nil != err {
 		return
 	}
-	l, err = Listener()
+	if l, err = Listener(); nil != err {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range []string{"FD", "NAME", "PPID"} {
+		if err = os.Unsetenv(envKey(name)); nil != err {
+			return
+		}
+	}
 	return
 }
 
 // Send SIGQUIT to the given ppid in order to complete the handoff to the
 // child process.
 func KillParent(ppid int) error {
-	return syscall.Kill(ppid, syscall.SIGQUIT)
+	mu.Lock()
+	defer mu.Unlock()
+	if err := kill(ppid, syscall.SIGQUIT); nil != err {
+		return err
+	}
+	letGo = true
+	return nil
+}
+
+// Send SIGQUIT to the given ppid and wait for it to exit.  If the context is
+// done first, return its error.
+func KillParentContext(ctx context.Context, ppid int) error {
+	if err := KillParent(ppid); nil != err {
+		return err
+	}
+	return awaitExit(ctx, ppid)
+}
+
+// ErrParentAlive is returned by KillParentTimeout when the parent process is
+// still running after the timeout.
+var ErrParentAlive = errors.New("goagain: parent process still running")
+
+// Send SIGQUIT to the given ppid and wait up to the timeout for it to exit.
+// If it hasn't and force is true, send it SIGKILL and wait up to the timeout
+// again.  Report whether it had to be killed so deploy tooling can tell a
+// graceful takeover from a forced one.  If it's still running after all,
+// return ErrParentAlive.
+func KillParentTimeout(ppid int, timeout time.Duration, force bool) (forced bool, err error) {
+	if err := KillParent(ppid); nil != err {
+		return false, err
+	}
+	if nil == awaitExitTimeout(ppid, timeout) {
+		event("parent-exit", []interface{}{"pid", ppid, "forced", false}, "parent", ppid, "exited")
+		return false, nil
+	}
+	if !force {
+		return false, ErrParentAlive
+	}
+	event(
+		"parent-kill",
+		[]interface{}{"pid", ppid},
+		"killing parent", ppid, "still running after", timeout,
+	)
+	if err := kill(ppid, syscall.SIGKILL); nil != err && syscall.ESRCH != err {
+		return true, err
+	}
+	if nil != awaitExitTimeout(ppid, timeout) {
+		return true, ErrParentAlive
+	}
+	event("parent-exit", []interface{}{"pid", ppid, "forced", true}, "parent", ppid, "killed")
+	return true, nil
+}
+
+func awaitExitTimeout(pid int, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return awaitExit(ctx, pid)
+}
+
+// Wait for the given parent process, signaled by KillParent, to actually
+// exit, as matters when it holds a lock or a PID file this process is about
+// to take, or until the timeout passes, returning context.DeadlineExceeded.
+// A zero timeout waits forever.  It's notified of the exit by a pidfd on
+// Linux, by kqueue on macOS and the BSDs, and by the process handle on
+// Windows, and polls elsewhere.
+func WaitForParentExit(ppid int, timeout time.Duration) error {
+	ctx := context.Background()
+	if 0 != timeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return awaitExit(ctx, ppid)
+}
+
+// Returned by waitExit where the platform can't be notified of a process
+// exiting so awaitExit polls instead.
+var errNoWaitExit = errors.New("goagain: can't wait for process exit")
+
+// Wait until the given process (which isn't our child so can't be waited
+// for) has exited or the context is done.  Our parent having exited is
+// noticed as soon as we're reparented, even before it's reaped.
+func awaitExit(ctx context.Context, pid int) error {
+	if err := waitExit(ctx, pid); errNoWaitExit != err {
+		return err
+	}
+	parent := syscall.Getppid() == pid
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for {
+		if parent && syscall.Getppid() != pid {
+			return nil
+		}
+		if syscall.ESRCH == kill(pid, 0) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
 }
@@ -0,0 +1,44 @@
+package goagain
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Linger, if not negative, is the SO_LINGER timeout in seconds applied to
+// every listener reconstructed from an inherited file descriptor.  The
+// option survives the handoff anyway since the socket itself does but it's
+// applied again in case the parent never set it.  Apply it to freshly bound
+// listeners with SetLinger.  Connections accepted from the listener inherit
+// it on Linux.
+var Linger = -1
+
+// Set the SO_LINGER timeout, in seconds, on a listener.  A negative timeout
+// disables lingering.
+func SetLinger(l net.Listener, sec int) error {
+	if u, ok := l.(interface {
+		Unwrap() net.Listener
+	}); ok {
+		return SetLinger(u.Unwrap(), sec)
+	}
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("SetLinger: %T has no file descriptor", l)
+	}
+	rc, err := sc.SyscallConn()
+	if nil != err {
+		return err
+	}
+	linger := &syscall.Linger{}
+	if 0 <= sec {
+		linger.Onoff, linger.Linger = 1, int32(sec)
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = setsockoptLinger(fd, linger)
+	}); nil != err {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,146 @@
+package goagain
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// Listeners inherited from the parent process not yet taken by Listen, keyed
+// by address, and the error reconstructing them, if any, guarded by mu.
+var (
+	inherited   map[string]net.Listener
+	inheritErr  error
+	inheritOnce sync.Once
+)
+
+// Return the listener inherited from the parent process, or passed by
+// systemd socket activation, on the given network and address, if there is
+// one, or else a fresh one.  Pair it with Manage,
+// which completes the handoff if the listener was inherited, to replace the
+// usual dance of trying Listener, falling back to net.Listen, and calling
+// Kill only in the former case.  Listen may be called once per address.
+func Listen(network, addr string) (net.Listener, error) {
+	if err := loadInherited(); nil != err {
+		return nil, err
+	}
+	mu.Lock()
+	for key, l := range inherited {
+		if sameAddr(l.Addr(), network, addr) {
+			delete(inherited, key)
+			mu.Unlock()
+			return l, nil
+		}
+	}
+	mu.Unlock()
+	return listenFresh(network, addr)
+}
+
+// Close the inherited listeners and datagram sockets no call to Listen,
+// ListenPacket, or PacketConns has taken.
+func closeInherited() {
+	mu.Lock()
+	defer mu.Unlock()
+	for key, l := range inherited {
+		l.Close()
+		delete(inherited, key)
+	}
+	for _, c := range inheritedPackets {
+		c.Close()
+	}
+	inheritedPackets = nil
+}
+
+// Reconstruct the listeners inherited from the parent process or passed by
+// systemd socket activation, if any, the first time this is called.
+func loadInherited() error {
+	inheritOnce.Do(func() {
+		if "" != getenv(envKey("FDS")) || "" != getenv(envKey("SOCKET")) {
+			ls, err := Listeners()
+			mu.Lock()
+			defer mu.Unlock()
+			inherited, inheritErr = ls, err
+			return
+		}
+		ls, cs, err := systemdSockets()
+		mu.Lock()
+		defer mu.Unlock()
+		inherited = make(map[string]net.Listener, len(ls))
+		for _, l := range ls {
+			inherited[l.Addr().String()] = l
+		}
+		inheritedPackets = append(inheritedPackets, cs...)
+		inheritErr = err
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	return inheritErr
+}
+
+// Report whether a listener's or connection's address is the given network
+// and address, with IPv4 and IPv6 variants of a network and unspecified IP
+// addresses alike.
+func sameAddr(a net.Addr, network, addr string) bool {
+	n := strings.TrimRight(network, "46")
+	if a.Network() != n {
+		return false
+	}
+	var (
+		ip, wantIP     net.IP
+		port, wantPort int
+	)
+	switch t := a.(type) {
+	case *net.TCPAddr:
+		want, err := net.ResolveTCPAddr(network, addr)
+		if nil != err {
+			return false
+		}
+		ip, port, wantIP, wantPort = t.IP, t.Port, want.IP, want.Port
+	case *net.UDPAddr:
+		want, err := net.ResolveUDPAddr(network, addr)
+		if nil != err {
+			return false
+		}
+		ip, port, wantIP, wantPort = t.IP, t.Port, want.IP, want.Port
+	case *net.UnixAddr:
+		return sameUnixName(t.Name, addr)
+	default:
+		return a.String() == addr
+	}
+	if port != wantPort {
+		return false
+	}
+	if nil == wantIP || wantIP.IsUnspecified() {
+		return ip.IsUnspecified()
+	}
+	return ip.Equal(wantIP)
+}
+
+// Report whether a Unix socket's name is the given one.  An abstract name may
+// be given with a leading NUL byte or, as the net package reports it, an @,
+// in which case it's cut short at any other NUL byte as the net package cuts
+// the name it reports.  An empty name, which Linux binds to an abstract name
+// of five hex digits, matches any such autobound name.
+func sameUnixName(name, want string) bool {
+	if strings.HasPrefix(want, "\x00") {
+		want = "@" + want[1:]
+	}
+	if strings.HasPrefix(want, "@") {
+		if i := strings.IndexByte(want, 0); -1 != i {
+			want = want[:i]
+		}
+	}
+	if "" == want {
+		return 6 == len(name) && '@' == name[0] && isHex(name[1:])
+	}
+	return name == want
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
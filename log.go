@@ -0,0 +1,46 @@
+package goagain
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// A Logger receives what goagain logs; *log.Logger is one.  A Logger that
+// also has an Event method like the one returned by SlogLogger receives
+// lifecycle events with their names and fields intact instead of as lines.
+type Logger interface {
+	Println(v ...interface{})
+}
+
+// Log, if not nil, receives everything goagain logs in place of the log
+// package's standard logger.  Set it to log.New(io.Discard, "", 0) to
+// silence goagain altogether.
+var Log Logger
+
+// Adapt a *slog.Logger as a Logger.  Lifecycle events are logged at the info
+// level with their names as messages and their fields as attributes.
+func SlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Println(v ...interface{}) {
+	s.l.Info(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+func (s slogLogger) Event(name string, kv []interface{}) {
+	s.l.Info(name, kv...)
+}
+
+func logln(v ...interface{}) {
+	if nil != conf().Log {
+		conf().Log.Println(v...)
+		return
+	}
+	log.Println(v...)
+}
@@ -0,0 +1,85 @@
+package goagain
+
+import (
+	"os"
+	"sync"
+)
+
+// A LogManager tracks log files by path and reopens them on SIGUSR1 so they
+// can be rotated out from under this process, as by logrotate(8).  Each file
+// is reopened in place: the newly opened file takes over the very same file
+// descriptor so writers holding the *os.File never see it closed.
+type LogManager struct {
+	mu    sync.Mutex
+	files map[string]*os.File
+}
+
+// Every LogManager, guarded by mu.
+var logManagers []*LogManager
+
+// Create a LogManager and register it so Wait reopens its files on SIGUSR1,
+// after OnSIGUSR1 and every Manager's OnSIGUSR1 are called.
+func NewLogManager() *LogManager {
+	lm := &LogManager{files: make(map[string]*os.File)}
+	mu.Lock()
+	defer mu.Unlock()
+	logManagers = append(logManagers, lm)
+	return lm
+}
+
+// Open the log file at the given path for appending, creating it if need be,
+// and track it.
+func (lm *LogManager) Open(path string) (*os.File, error) {
+	f, err := openLog(path)
+	if nil != err {
+		return nil, err
+	}
+	lm.Add(path, f)
+	return f, nil
+}
+
+// Track a log file already open, such as one recovered by LogFile, that
+// should be reopened from the given path.
+func (lm *LogManager) Add(path string, f *os.File) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.files[path] = f
+}
+
+// Reopen every tracked log file from its path and return the first error
+// encountered, if any.  A file that can't be reopened is left as it was.
+func (lm *LogManager) Reopen() error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	var first error
+	for path, f := range lm.files {
+		if err := reopenLog(path, f); nil != err && nil == first {
+			first = err
+		}
+	}
+	return first
+}
+
+func openLog(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+func reopenLog(path string, f *os.File) error {
+	nf, err := openLog(path)
+	if nil != err {
+		return err
+	}
+	defer nf.Close()
+	return dup2(int(nf.Fd()), int(f.Fd()))
+}
+
+func reopenLogs() {
+	mu.Lock()
+	lms := append([]*LogManager(nil), logManagers...)
+	mu.Unlock()
+	for _, lm := range lms {
+		if err := lm.Reopen(); nil != err {
+			hookError("LogManager", err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package goagain
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as the child process the tests spawn, which does
+// as TEST_GOAGAIN_CHILD says:
+//
+//	hang   never take over
+//	exit   exit at once with status 3
+//	print  write "stdout" and "stderr" to those streams and exit
+//	serve  take over the inherited listener and answer each connection with
+//	       its own PID until signaled to stop
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("TEST_GOAGAIN_CHILD"); "" != mode {
+		testChild(mode)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func testChild(mode string) {
+	switch mode {
+	case "hang":
+		time.Sleep(time.Minute)
+	case "exit":
+		os.Exit(3)
+	case "print":
+		fmt.Fprint(os.Stdout, "stdout")
+		fmt.Fprint(os.Stderr, "stderr")
+	case "serve":
+		l, err := Listener()
+		if nil != err {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		go AcceptLoop(l, func(c net.Conn) {
+			fmt.Fprintln(c, os.Getpid())
+			c.Close()
+		})
+		if err := Kill(); nil != err {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if _, err := Wait(l); nil != err {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	os.Exit(0)
+}
+
+// Make ForkExec spawn this test binary as a child that does as mode says,
+// until the test ends.
+func spawnTestChild(t *testing.T, mode string) {
+	t.Helper()
+	exe, args, extra := Executable, Args, EnvExtra
+	Executable, Args = os.Args[0], []string{os.Args[0]}
+	EnvExtra = []string{"TEST_GOAGAIN_CHILD=" + mode}
+	t.Cleanup(func() { Executable, Args, EnvExtra = exe, args, extra })
+}
+
+// Listen on a loopback port until the test ends.
+func testListener(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// Test that a connection to the listener is accepted by this process.
+func testAccept(t *testing.T, l net.Listener) {
+	t.Helper()
+	go func() {
+		if c, err := net.Dial("tcp", l.Addr().String()); nil == err {
+			c.Close()
+		}
+	}()
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := l.Accept()
+	if nil != err {
+		t.Fatal(err)
+	}
+	c.Close()
+	l.(*net.TCPListener).SetDeadline(time.Time{})
+}
+
+// Wait for the child in flight to be reaped, once it's exited on its own.
+func waitChild(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	done := reaped
+	mu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("child never exited")
+	}
+}
+
+// Put back the state a restart or a shutdown leaves behind once the test
+// ends, so the next test starts as a fresh process would.
+func resetAfter(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		handedOff, successor, letGo, yielding = false, 0, false, false
+		failures, throttled, lastAttempt = 0, false, time.Time{}
+		mu.Unlock()
+		shuttingDown, shutdownOnce = make(chan struct{}), sync.Once{}
+	})
+}
+
+// Wait in the background as Manage does and return a channel on which the
+// signal that ended the wait is sent.
+func manageAsync(t *testing.T, l net.Listener) <-chan syscall.Signal {
+	t.Helper()
+	ch := make(chan syscall.Signal, 1)
+	go func() {
+		sig, err := Manage(l)
+		if nil != err {
+			t.Error(err)
+		}
+		ch <- sig
+	}()
+	return ch
+}
+
+// Receive the signal that ended a wait or fail after a while.
+func receiveSignal(t *testing.T, ch <-chan syscall.Signal) syscall.Signal {
+	t.Helper()
+	select {
+	case sig := <-ch:
+		return sig
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for Wait to return")
+	}
+	return 0
+}
+
+// Return the PID of the process that answered a connection to a listener
+// served by a child in "serve" mode.
+func dialPID(t *testing.T, addr string) int {
+	t.Helper()
+	c, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	var pid int
+	if _, err := fmt.Fscan(c, &pid); nil != err {
+		t.Fatal(err)
+	}
+	return pid
+}
+
+// Stop the child that's taken over once the test ends and wait for it to be
+// reaped.
+func stopChildAfter(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	done, pid := reaped, successor
+	mu.Unlock()
+	t.Cleanup(func() {
+		if 0 != pid {
+			kill(pid, syscall.SIGTERM)
+		}
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Error("child never exited")
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package goagain
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+type hook func(l net.Listener) error
+
+// A Manager owns a set of listeners and the hooks called on their behalf so
+// several servers in one process can take part in one coordinated restart.
+// Exec and ForkExec hand every Manager's listeners to the new process along
+// with the listener passed to them directly, which belongs to the default
+// Manager made up of the package-level functions and variables.  Wait calls
+// every Manager's hooks as well as the package-level ones.  Strategy and the
+// other package-level options apply to the process as a whole since there's
+// only one new process per restart.
+type Manager struct {
+	// OnSIGHUP, OnSIGUSR1, and PreHandoff are called for each of this
+	// Manager's listeners as their package-level namesakes are called for
+	// the default listener.
+	OnSIGHUP, OnSIGUSR1, PreHandoff func(l net.Listener) error
+
+	name      string
+	listeners []net.Listener
+}
+
+// Every Manager, guarded by mu.
+var managers []*Manager
+
+// Create and register a Manager.  Its name identifies its listeners in the
+// environment so it must be unique within the process and stable across
+// restarts.
+func NewManager(name string) (*Manager, error) {
+	if "" == name || strings.ContainsAny(name, "/,=") {
+		return nil, fmt.Errorf("invalid Manager name %q", name)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, m := range managers {
+		if name == m.name {
+			return nil, fmt.Errorf("duplicate Manager name %q", name)
+		}
+	}
+	m := &Manager{name: name}
+	managers = append(managers, m)
+	return m, nil
+}
+
+// Add a listener to be handed to the new process on restart.
+func (m *Manager) Add(l net.Listener) {
+	mu.Lock()
+	defer mu.Unlock()
+	m.listeners = append(m.listeners, l)
+}
+
+// Reconstruct this Manager's listeners from the file descriptors inherited
+// from the parent process, in the order they were added there, and add them
+// to this Manager.
+func (m *Manager) Inherit() ([]net.Listener, error) {
+	manifest, err := Manifest()
+	if nil != err {
+		return nil, err
+	}
+	fds := make(map[int]uintptr)
+	indices := make([]int, 0, len(manifest))
+	for purpose, fd := range manifest {
+		if !strings.HasPrefix(purpose, m.name+"/") {
+			continue
+		}
+		var i int
+		if _, err := fmt.Sscan(purpose[len(m.name)+1:], &i); nil != err {
+			return nil, fmt.Errorf("malformed manifest entry %q", purpose)
+		}
+		fds[i] = fd
+		indices = append(indices, i)
+	}
+	if 0 == len(indices) {
+		return nil, fmt.Errorf("no listeners inherited for Manager %q", m.name)
+	}
+	sort.Ints(indices)
+	ls := make([]net.Listener, 0, len(indices))
+	for _, i := range indices {
+		l, err := fileListener(fds[i], fmt.Sprintf("%s/%d", m.name, i))
+		if nil != err {
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+	for _, l := range ls {
+		m.Add(l)
+	}
+	return ls, nil
+}
+
+// Return the listeners this Manager owns.
+func (m *Manager) Listeners() []net.Listener {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]net.Listener(nil), m.listeners...)
+}
+
+func callHooks(name string, ls []net.Listener, f hook, pick func(*Manager) hook) {
+	for _, l := range ls {
+		if nil == l || nil == f {
+			continue
+		}
+		if err := f(l); nil != err {
+			hookError(name, err)
+		}
+	}
+	mu.Lock()
+	ms := append([]*Manager(nil), managers...)
+	mu.Unlock()
+	for _, m := range ms {
+		f := pick(m)
+		if nil == f {
+			continue
+		}
+		for _, l := range m.Listeners() {
+			if err := f(l); nil != err {
+				hookError(m.name+" "+name, err)
+			}
+		}
+	}
+}
+
+func hookError(name string, err error) {
+	if nil != conf().OnHookError {
+		conf().OnHookError(name, err)
+		return
+	}
+	logln(name+":", err)
+}
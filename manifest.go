@@ -0,0 +1,88 @@
+package goagain
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// InheritProtocol is the version of the protocol, described in the README,
+// by which a parent hands its listeners and files to a child through
+// GOAGAIN_* environment variables and inherited file descriptors.  The
+// parent sets GOAGAIN_INHERIT to it so a child, which may be an entirely
+// different program not written in Go, can tell whether it knows what it's
+// inheriting.  It changes only when the protocol changes incompatibly.
+const InheritProtocol = 1
+
+// Warn if the parent speaks a later protocol than this process, which may
+// then misread what it inherits.
+func loadInherit() {
+	var n int
+	if _, err := fmt.Sscan(os.Getenv(envKey("INHERIT")), &n); nil == err && n > InheritProtocol {
+		logln("parent speaks protocol", n, "but this process only", InheritProtocol)
+	}
+}
+
+// Manifest returns the file descriptors inherited from the parent process,
+// keyed by purpose, as recorded by the parent in GOAGAIN_MANIFEST.
+func Manifest() (map[string]uintptr, error) {
+	m := make(map[string]uintptr)
+	s := getenv(envKey("MANIFEST"))
+	if "" == s {
+		return m, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		var (
+			fd      uintptr
+			purpose string
+		)
+		i := strings.Index(entry, "=")
+		if -1 == i {
+			return nil, fmt.Errorf("malformed manifest entry %q", entry)
+		}
+		if _, err := fmt.Sscan(entry[:i], &fd); nil != err {
+			return nil, fmt.Errorf("malformed manifest entry %q: %v", entry, err)
+		}
+		purpose = entry[i+1:]
+		if _, ok := m[purpose]; ok {
+			return nil, fmt.Errorf("duplicate manifest entry %q", purpose)
+		}
+		m[purpose] = fd
+	}
+	return m, nil
+}
+
+// Check that the file descriptors inherited from the parent process serve
+// exactly the given purposes, no more and no fewer, so a parent and child
+// that disagree fail loudly instead of misbehaving.
+func CheckManifest(purposes ...string) error {
+	m, err := Manifest()
+	if nil != err {
+		return err
+	}
+	inherited := make([]string, 0, len(m))
+	for purpose := range m {
+		inherited = append(inherited, purpose)
+	}
+	expected := append([]string(nil), purposes...)
+	sort.Strings(inherited)
+	sort.Strings(expected)
+	if strings.Join(inherited, ",") != strings.Join(expected, ",") {
+		return fmt.Errorf(
+			"manifest mismatch: expected %v, inherited %v",
+			expected,
+			inherited,
+		)
+	}
+	return nil
+}
+
+func formatManifest(m map[string]uintptr) string {
+	entries := make([]string, 0, len(m))
+	for purpose, fd := range m {
+		entries = append(entries, fmt.Sprintf("%d=%s", fd, purpose))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,76 @@
+//go:build !windows
+
+package goagain
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	t.Setenv(envKey("MANIFEST"), "3=listener,4=listener/1,7=state")
+	m, err := Manifest()
+	if nil != err {
+		t.Fatal(err)
+	}
+	want := map[string]uintptr{"listener": 3, "listener/1": 4, "state": 7}
+	if len(want) != len(m) {
+		t.Fatalf("manifest %v, not %v", m, want)
+	}
+	for purpose, fd := range want {
+		if m[purpose] != fd {
+			t.Errorf("manifest %v, not %v", m, want)
+		}
+	}
+	if err := CheckManifest("state", "listener", "listener/1"); nil != err {
+		t.Error(err)
+	}
+	if err := CheckManifest("listener", "listener/1"); nil == err {
+		t.Error("CheckManifest accepted a file it wasn't told to expect")
+	}
+	if err := CheckManifest("listener", "listener/1", "state", "log"); nil == err {
+		t.Error("CheckManifest accepted a missing file")
+	}
+}
+
+func TestManifestMalformed(t *testing.T) {
+	for _, s := range []string{"3", "x=listener", "3=listener,4=listener"} {
+		t.Setenv(envKey("MANIFEST"), s)
+		if _, err := Manifest(); nil == err {
+			t.Errorf("Manifest accepted %q", s)
+		}
+	}
+}
+
+// The manifest a parent records describes the duplicates it hands the child.
+func TestSetEnvsManifest(t *testing.T) {
+	l0, l1 := testListener(t), testListener(t)
+	v := make(envVars)
+	mu.Lock()
+	fds, err := setEnvs(v, []net.Listener{l0, l1})
+	mu.Unlock()
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer closeFDs(fds)
+	t.Setenv(envKey("MANIFEST"), v[envKey("MANIFEST")])
+	m, err := Manifest()
+	if nil != err {
+		t.Fatal(err)
+	}
+	for purpose, l := range map[string]net.Listener{"listener": l0, "listener/1": l1} {
+		fd, ok := m[purpose]
+		if !ok {
+			t.Errorf("no %s in manifest %v", purpose, m)
+			continue
+		}
+		sa, err := syscall.Getsockname(int(fd))
+		if nil != err {
+			t.Fatal(err)
+		}
+		if port := sa.(*syscall.SockaddrInet4).Port; l.Addr().(*net.TCPAddr).Port != port {
+			t.Errorf("%s is bound to port %d, not %v", purpose, port, l.Addr())
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package goagain
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// A Master holds the listeners and runs a number of worker processes that
+// accept connections from them, as Unicorn does.  Workers that exit are
+// replaced.  SIGUSR2 forks and execs a new master, which starts workers of
+// its own and then tells this one to let go, whereupon this one's workers
+// drain and exit.  SIGHUP and SIGUSR1 are passed on to every worker.  SIGTTIN
+// and SIGTTOU add and remove a worker.
+//
+// The same program is both master and worker: Worker reports which one this
+// process is.
+type Master struct {
+	ls      []net.Listener
+	n       int
+	workers map[int]*os.Process
+	started map[int]time.Time
+	next    map[int]time.Time
+}
+
+// Create a Master that runs n workers accepting connections from the given
+// listeners.
+func NewMaster(n int, ls ...net.Listener) *Master {
+	return &Master{
+		ls:      ls,
+		n:       n,
+		workers: make(map[int]*os.Process),
+		started: make(map[int]time.Time),
+		next:    make(map[int]time.Time),
+	}
+}
+
+// Start the workers, complete the handoff if this master was started by
+// another, and block handling signals and replacing workers that exit.
+// Return the signal that ended the wait once every worker has exited.
+func (m *Master) Run() (syscall.Signal, error) {
+	type exit struct{ id, pid int }
+	exited := make(chan exit)
+	spawn := func(id int) error {
+		p, err := spawnWorker(id, m.ls)
+		if nil != err {
+			return err
+		}
+		m.workers[id], m.started[id] = p, time.Now()
+		go func() {
+			p.Wait()
+			exited <- exit{id, p.Pid}
+		}()
+		return nil
+	}
+	fill := func() {
+		now := time.Now()
+		for id := 0; id < m.n; id++ {
+			if _, ok := m.workers[id]; ok || now.Before(m.next[id]) {
+				continue
+			}
+			if err := spawn(id); nil != err {
+				logln("spawning worker", id, err)
+				m.next[id] = now.Add(time.Second)
+			}
+		}
+	}
+	stop := func(sig syscall.Signal) {
+		m.signal(sig)
+		for 0 < len(m.workers) {
+			e := <-exited
+			if p, ok := m.workers[e.id]; ok && p.Pid == e.pid {
+				delete(m.workers, e.id)
+			}
+		}
+	}
+
+	fill()
+	if "" != getenv(envKey("PPID")) {
+		if err := Kill(); nil != err {
+			stop(syscall.SIGQUIT)
+			return 0, err
+		}
+	}
+	if err := writePIDFile(); nil != err {
+		stop(syscall.SIGQUIT)
+		return 0, err
+	}
+	notifyReady()
+	setRole("master, " + servingRole())
+
+	ch := make(chan os.Signal, 2)
+	notifySignals(
+		ch,
+		syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM,
+		sigTTIN,
+		sigTTOU,
+		sigUSR1,
+		sigUSR2,
+	)
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+
+		// Replace a worker that exited, after a second if it didn't
+		// last that long so a crashing worker doesn't spin.
+		case e := <-exited:
+			p, ok := m.workers[e.id]
+			if !ok || p.Pid != e.pid {
+				continue
+			}
+			delete(m.workers, e.id)
+			if e.id >= m.n {
+				continue
+			}
+			event(
+				"worker-exit",
+				[]interface{}{"worker", e.id, "pid", e.pid},
+				"worker", e.id, "exited",
+			)
+			if time.Since(m.started[e.id]) < time.Second {
+				m.next[e.id] = time.Now().Add(time.Second)
+			}
+			fill()
+
+		case <-t.C:
+			fill()
+
+		case sig := <-ch:
+			event("signal", []interface{}{"signal", fmt.Sprintf("%d", sig)}, sig.String())
+			switch sig {
+
+			case syscall.SIGHUP, sigUSR1:
+				m.signal(sig.(syscall.Signal))
+
+			// SIGQUIT drains the workers gracefully; it's how a new
+			// master, if one's in flight, takes over.
+			case syscall.SIGQUIT:
+				if p := getChild(); nil != p {
+					notifyHandoff(p.Pid)
+					setRole("old master, draining")
+				} else {
+					notifyStopping()
+					setRole("master, shutting down")
+				}
+				setChild(nil)
+				stop(syscall.SIGQUIT)
+				return syscall.SIGQUIT, nil
+
+			// SIGTTIN adds a worker and SIGTTOU gracefully stops the
+			// last one.
+			case sigTTIN:
+				m.n++
+				fill()
+			case sigTTOU:
+				if m.n <= 1 {
+					continue
+				}
+				m.n--
+				if p, ok := m.workers[m.n]; ok {
+					p.Signal(syscall.SIGQUIT)
+				}
+
+			case syscall.SIGINT, syscall.SIGTERM:
+				notifyStopping()
+				setRole("master, shutting down")
+				stop(sig.(syscall.Signal))
+				return sig.(syscall.Signal), nil
+
+			case sigUSR2:
+				if nil != getChild() {
+					continue
+				}
+				if err := ForkExec(m.ls...); nil != err {
+					logln("spawning master", err)
+				}
+
+			}
+		}
+	}
+}
+
+// Send a signal to every worker.
+func (m *Master) signal(sig syscall.Signal) {
+	for _, p := range m.workers {
+		p.Signal(sig)
+	}
+}
+
+// Report whether this process is a worker started by a Master and, if so,
+// which one.  A worker recovers its listeners with Listeners.
+func Worker() (id int, ok bool) {
+	_, err := fmt.Sscan(getenv(envKey("WORKER")), &id)
+	return id, nil == err
+}
+
+// Block a worker awaiting signals from its Master.  SIGHUP and SIGUSR1 call
+// the same hooks Wait calls; SIGINT, SIGQUIT, and SIGTERM end the wait, after
+// which the worker should drain (given SIGQUIT) and exit.
+func WaitWorker(ls ...net.Listener) syscall.Signal {
+	if id, ok := Worker(); ok {
+		setRole(fmt.Sprintf("worker %d", id))
+	}
+	ch := make(chan os.Signal, 2)
+	notifySignals(
+		ch,
+		syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM,
+		sigUSR1,
+	)
+	for {
+		sig := <-ch
+		switch sig {
+		case syscall.SIGHUP:
+			callHooks("OnSIGHUP", ls, conf().OnSIGHUP, func(m *Manager) hook {
+				return m.OnSIGHUP
+			})
+			reload()
+		case sigUSR1:
+			callHooks("OnSIGUSR1", ls, conf().OnSIGUSR1, func(m *Manager) hook {
+				return m.OnSIGUSR1
+			})
+			reopenLogs()
+		default:
+			return sig.(syscall.Signal)
+		}
+	}
+}
+
+// Fork and exec a worker with the given listeners.  Workers don't take part
+// in the restart protocol so they're given no parent to signal.
+func spawnWorker(id int, ls []net.Listener) (*os.Process, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	argv0, err := lookPath()
+	if nil != err {
+		return nil, err
+	}
+	wd, err := workDir()
+	if nil != err {
+		return nil, err
+	}
+	v := make(envVars)
+	fds, err := setEnvs(v, ls)
+	if nil != err {
+		return nil, err
+	}
+	defer closeFDs(fds)
+	v.set("GENERATION", fmt.Sprint(generation))
+	v.set("WORKER", fmt.Sprint(id))
+	v.set("PID", "")
+	v.set("PPID", "")
+	v.set("SIGNAL", "")
+	env := childEnv(v.environ())
+	std, closeStd, err := childStreams()
+	if nil != err {
+		return nil, err
+	}
+	pid, err := spawn(argv0, argv(), env, wd, std, fds)
+	closeStd()
+	if nil != err {
+		return nil, err
+	}
+	event(
+		"worker",
+		[]interface{}{"worker", id, "pid", pid},
+		"spawned worker", id, pid,
+	)
+	return os.FindProcess(pid)
+}
@@ -0,0 +1,87 @@
+package goagain
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Metrics counts this process' restarts and drains for monitoring.  Unlike
+// Stats, which follows restarts across generations, the counters start from
+// zero in every process.
+type Metrics struct {
+
+	// UpgradesAttempted counts children spawned or attempted, not counting
+	// those refused by throttling.  UpgradesSucceeded counts children that
+	// took over and UpgradesFailed those that couldn't be spawned or were
+	// killed or exited before taking over.
+	UpgradesAttempted, UpgradesSucceeded, UpgradesFailed int
+
+	// SpawnLatency is how long the last child that took over took from
+	// being spawned to taking over.
+	SpawnLatency time.Duration
+
+	// DrainDuration is how long the last drain took and DrainConnections
+	// the number of connections active when it began.
+	DrainDuration    time.Duration
+	DrainConnections int
+
+	// Generation is as reported by Generation.
+	Generation int
+}
+
+var (
+	metricsMu sync.Mutex
+	metrics   Metrics
+)
+
+// Return a snapshot of this process' metrics.
+func RestartMetrics() Metrics {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	m := metrics
+	m.Generation = generation
+	return m
+}
+
+// Return an expvar.Var reporting RestartMetrics for publishing under a name
+// of the caller's choosing, for example:
+//
+//	expvar.Publish("goagain", goagain.ExpvarMetrics())
+func ExpvarMetrics() expvar.Var {
+	return expvar.Func(func() interface{} {
+		return RestartMetrics()
+	})
+}
+
+func countMetric(f func(*Metrics)) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	f(&metrics)
+}
+
+// Count a successful upgrade and how long the child took to take over.
+func upgradeSucceeded() {
+	statsMu.Lock()
+	t := spawned
+	statsMu.Unlock()
+	countMetric(func(m *Metrics) {
+		m.UpgradesSucceeded++
+		if !t.IsZero() {
+			m.SpawnLatency = time.Since(t)
+		}
+	})
+}
+
+// Note how many connections are active as a drain begins and return a
+// function that records how long it took.
+func drainStarted() func() {
+	start, n := time.Now(), ActiveConnections()
+	done := statusDrain()
+	return func() {
+		done()
+		countMetric(func(m *Metrics) {
+			m.DrainDuration, m.DrainConnections = time.Since(start), n
+		})
+	}
+}
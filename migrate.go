@@ -0,0 +1,220 @@
+//go:build !windows
+
+package goagain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// ConnMigration, if true, makes ForkExec give the child a Unix socket over
+// which this process can hand it accepted connections, each with a blob of
+// state, by MigrateConn, so even established connections survive a restart
+// for protocols that can resume mid-stream.  The child takes them with
+// ReceiveConns.  Set it before calling Wait.
+var ConnMigration bool
+
+// ErrNoMigration is returned by MigrateConn when no child was given a socket
+// to receive connections over, because ConnMigration isn't set or no child
+// has been spawned.
+var ErrNoMigration = errors.New("goagain: no child to migrate connections to")
+
+// The most state sent with one connection.
+const maxMigrateState = 1 << 20
+
+// This process' end of the socket over which it migrates connections to its
+// child, guarded by migrateMu, which also keeps migrations from interleaving.
+var (
+	migrateMu   sync.Mutex
+	migrateConn *net.UnixConn
+)
+
+// Hand an accepted connection to the child, as the child sees it from the
+// moment it's spawned until this process exits or calls EndMigration, and
+// close it here.  Nothing is read from or written to it meanwhile, so it
+// should be idle, as a keep-alive connection between requests is, or its
+// state should say where to resume, including anything already read into
+// a buffer.  Connections wrapped by TLS or anything else that keeps state
+// of its own can't be migrated.
+func MigrateConn(c net.Conn, state []byte) error {
+	if len(state) > maxMigrateState {
+		return fmt.Errorf("%d bytes of state is more than %d", len(state), maxMigrateState)
+	}
+	var inner net.Conn = c
+	if tc, ok := c.(*trackedConn); ok {
+		inner = tc.Conn
+	}
+	sc, ok := inner.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("%T has no file descriptor to migrate", inner)
+	}
+	fd, err := dupConn(sc)
+	if nil != err {
+		return err
+	}
+	defer syscall.Close(fd)
+	migrateMu.Lock()
+	defer migrateMu.Unlock()
+	if nil == migrateConn {
+		return ErrNoMigration
+	}
+	b := make([]byte, 4+len(state))
+	binary.BigEndian.PutUint32(b, uint32(len(state)))
+	copy(b[4:], state)
+	if _, _, err := migrateConn.WriteMsgUnix(b, syscall.UnixRights(fd), nil); nil != err {
+		return err
+	}
+	event(
+		"migrate",
+		[]interface{}{"remote", c.RemoteAddr().String(), "state", len(state)},
+		"migrated connection from", c.RemoteAddr(), "with", len(state), "bytes of state",
+	)
+	return c.Close()
+}
+
+// Tell the child no more connections are coming, which ends its
+// ReceiveConns, rather than leave it waiting until this process exits.
+func EndMigration() error {
+	return endMigration()
+}
+
+func endMigration() error {
+	migrateMu.Lock()
+	defer migrateMu.Unlock()
+	if nil == migrateConn {
+		return ErrNoMigration
+	}
+	err := migrateConn.Close()
+	migrateConn = nil
+	return err
+}
+
+// Call the given function with every connection the parent process hands
+// over by MigrateConn, and its state, until the parent exits or calls
+// EndMigration.  Return ErrNotInherited if the parent doesn't migrate
+// connections.  The function must not block; it typically starts a
+// goroutine to resume serving the connection.
+func ReceiveConns(handle func(c net.Conn, state []byte)) error {
+	manifest, err := Manifest()
+	if nil != err {
+		return err
+	}
+	fd, ok := manifest["migrate"]
+	if !ok {
+		return ErrNotInherited
+	}
+	closeOnExec(fd)
+	f := os.NewFile(fd, "migrate")
+	fc, err := net.FileConn(f)
+	f.Close()
+	if nil != err {
+		return err
+	}
+	defer fc.Close()
+	uc, ok := fc.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("inherited %T, not a Unix socket, to migrate connections over", fc)
+	}
+	hdr, oob := make([]byte, 4), make([]byte, syscall.CmsgSpace(4))
+	for {
+		n, oobn, _, _, err := uc.ReadMsgUnix(hdr, oob)
+		if 0 == n && io.EOF == err {
+			return nil
+		}
+		if nil != err {
+			return err
+		}
+		var fds []int
+		scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if nil != err {
+			return err
+		}
+		for _, scm := range scms {
+			rights, err := syscall.ParseUnixRights(&scm)
+			if nil != err {
+				closeFDs(fds)
+				return err
+			}
+			fds = append(fds, rights...)
+		}
+		if _, err := io.ReadFull(uc, hdr[n:]); nil != err {
+			closeFDs(fds)
+			return err
+		}
+		state := make([]byte, binary.BigEndian.Uint32(hdr))
+		if _, err := io.ReadFull(uc, state); nil != err {
+			closeFDs(fds)
+			return err
+		}
+		if 1 != len(fds) {
+			closeFDs(fds)
+			return fmt.Errorf("%d file descriptors received with one connection's state", len(fds))
+		}
+		syscall.CloseOnExec(fds[0])
+		f := os.NewFile(uintptr(fds[0]), "migrated")
+		c, err := net.FileConn(f)
+		f.Close()
+		if nil != err {
+			logln("resuming migrated connection", err)
+			continue
+		}
+		handle(c, state)
+	}
+}
+
+// Open a socket for the child about to be spawned to receive connections
+// over, if ConnMigration is set, replacing any opened for an earlier child,
+// and return the child's end, which is the caller's to close once the child's
+// spawned.  Call with mu held.
+func openMigration() (*os.File, error) {
+	migrateMu.Lock()
+	defer migrateMu.Unlock()
+	if nil != migrateConn {
+		migrateConn.Close()
+		migrateConn = nil
+	}
+	if !conf().ConnMigration {
+		return nil, nil
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if nil != err {
+		return nil, err
+	}
+	syscall.CloseOnExec(fds[0])
+	f := os.NewFile(uintptr(fds[0]), "migrate")
+	c, err := net.FileConn(f)
+	f.Close()
+	if nil != err {
+		syscall.Close(fds[1])
+		return nil, err
+	}
+	migrateConn = c.(*net.UnixConn)
+	theirs := os.NewFile(uintptr(fds[1]), "migrate")
+	extraFiles["migrate"] = theirs
+	return theirs, nil
+}
+
+// Close the child's end of the socket now that the child's been spawned, or
+// the whole socket if it hasn't.  Call with mu held.
+func closeMigration(f *os.File, spawned bool) {
+	if nil == f {
+		return
+	}
+	delete(extraFiles, "migrate")
+	f.Close()
+	if spawned {
+		return
+	}
+	migrateMu.Lock()
+	defer migrateMu.Unlock()
+	if nil != migrateConn {
+		migrateConn.Close()
+		migrateConn = nil
+	}
+}
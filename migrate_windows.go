@@ -0,0 +1,39 @@
+package goagain
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// ConnMigration has no effect on Windows, which has no SCM_RIGHTS.
+var ConnMigration bool
+
+// ErrNoMigration is returned by MigrateConn when no child was given a socket
+// to receive connections over.
+var ErrNoMigration = errors.New("goagain: no child to migrate connections to")
+
+// Return an error since Windows has no SCM_RIGHTS.
+func MigrateConn(c net.Conn, state []byte) error {
+	return errNoInherit
+}
+
+// Return an error since Windows has no SCM_RIGHTS.
+func EndMigration() error {
+	return errNoInherit
+}
+
+// Return an error since Windows has no SCM_RIGHTS.
+func ReceiveConns(handle func(c net.Conn, state []byte)) error {
+	return errNoInherit
+}
+
+func endMigration() error {
+	return nil
+}
+
+func openMigration() (*os.File, error) {
+	return nil, nil
+}
+
+func closeMigration(f *os.File, spawned bool) {}
@@ -0,0 +1,49 @@
+package goagain
+
+import "fmt"
+
+// Whether this process has handed off to a child, which systemd now tracks
+// as the service's main process, and that child's PID, guarded by mu.
+var (
+	handedOff bool
+	successor int
+)
+
+// Tell systemd this process is serving.  Under the Single strategy the
+// parent names the child the main process as it hands off, so the child's
+// READY=1 only matters to systemd when it started the service or the unit
+// sets NotifyAccess=all.
+func notifyReady() {
+	if err := SDNotify("READY=1"); nil != err {
+		logln("notifying systemd", err)
+	}
+}
+
+// Tell systemd the child with the given PID is taking over as the service's
+// main process, so this process exiting doesn't look like the service
+// exiting.  Under the Double strategy this process keeps serving as itself.
+func notifyHandoff(pid int) {
+	mu.Lock()
+	handedOff, successor = true, pid
+	mu.Unlock()
+	if Double == conf().Strategy {
+		return
+	}
+	if err := SDNotify(fmt.Sprintf("MAINPID=%d\nREADY=1", pid)); nil != err {
+		logln("notifying systemd", err)
+	}
+}
+
+// Tell systemd the service is stopping, unless this process handed off to
+// another or is the child yielding to its parent under the Double strategy.
+func notifyStopping() {
+	mu.Lock()
+	quiet := handedOff || yielding
+	mu.Unlock()
+	if quiet {
+		return
+	}
+	if err := SDNotify("STOPPING=1"); nil != err {
+		logln("notifying systemd", err)
+	}
+}
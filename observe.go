@@ -0,0 +1,63 @@
+package goagain
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// A process re-executed by ObserveAfterHandoff finds GOAGAIN_OBSERVE in its
+// environment and observes its successor instead of running main.
+func loadObserve() {
+	var (
+		pid int
+		d   time.Duration
+	)
+	if _, err := fmt.Sscan(os.Getenv(envKey("OBSERVE")), &pid, &d); nil != err {
+		return
+	}
+	os.Exit(observe(pid, d))
+}
+
+// Under the Single strategy, re-exec this process as an observer of the
+// child that took over from it instead of exiting, once the graceful shutdown
+// is otherwise complete.  The observer holds no listeners; it logs the
+// child's health every second for the given duration and then exits, with
+// status 1 if the child exited first.  This helps debug restart loops.
+func ObserveAfterHandoff(d time.Duration) error {
+	mu.Lock()
+	pid := successor
+	mu.Unlock()
+	if 0 == pid {
+		return fmt.Errorf("ObserveAfterHandoff: no child to observe")
+	}
+	argv0, err := exec.LookPath(os.Args[0])
+	if nil != err {
+		return err
+	}
+	v := make(envVars)
+	v.set("OBSERVE", fmt.Sprintf("%d %d", pid, int64(d)))
+	logln("observing child", pid, "for", d)
+	return syscall.Exec(argv0, os.Args, v.environ())
+}
+
+func observe(pid int, d time.Duration) int {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	deadline := time.After(d)
+	for {
+		select {
+		case <-deadline:
+			logln("done observing child", pid)
+			return 0
+		case <-t.C:
+		}
+		if err := kill(pid, 0); nil != err {
+			logln("child", pid, "is gone:", err)
+			return 1
+		}
+		logln("child", pid, "is running")
+	}
+}
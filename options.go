@@ -0,0 +1,225 @@
+package goagain
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrInvalidOptions is returned, wrapped in an error describing the problem,
+// by New and NewUpgrader given Options that don't make sense.
+var ErrInvalidOptions = errors.New("goagain: invalid Options")
+
+// Options configures an Upgrader.  Most fields set the package-level
+// variable of the same name, documented there, as New creates the Upgrader;
+// the zero value of each leaves that variable as it is, so Options may be
+// mixed with setting the variables directly.
+type Options struct {
+
+	// EnvPrefix, if not empty, changes the prefix of goagain's environment
+	// variables.  See SetEnvPrefix.
+	EnvPrefix string
+
+	// Strategy, if not Single, is the strategy to use.  The Upgrader
+	// doesn't support the Double strategy.
+	Strategy strategy
+
+	// ReadySignal and SignalMap change the signals Wait handles.
+	ReadySignal syscall.Signal
+	SignalMap   map[syscall.Signal]syscall.Signal
+
+	// ReadyTimeout, if not zero, is the longest a child is given to say
+	// it's ready to take over before it's killed.
+	ReadyTimeout time.Duration
+
+	// The rest of the timeouts that govern a restart.
+	RestartWhenIdle time.Duration
+	Overlap         time.Duration
+	GraceDeadline   time.Duration
+
+	// Throttle restarts that keep failing.
+	MinRestartInterval time.Duration
+	MaxRestartFailures int
+
+	// What the processes ForkExec spawns run and where, and where the PID
+	// of the process serving is kept.
+	Executable string
+	Args       []string
+	Dir        string
+	PIDFile    string
+
+	// State, if true, gives every child a pipe from its parent over which
+	// to stream application state.  See StateWriter and StateReader.
+	State bool
+
+	// Logger, if not nil, receives everything goagain logs.  See Log.
+	Logger Logger
+
+	// Hooks called through a restart.
+	PreReady       func() error
+	OnBeforeExec   func() error
+	OnChildSpawned func(pid int) error
+	OnParentExit   func() error
+	PreHandoff     func(l net.Listener) error
+	OnHookError    func(name string, err error)
+}
+
+// Return an error describing the first problem with the options, if any.
+func (opts *Options) validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidOptions, fmt.Sprintf(format, args...))
+	}
+	if "" != opts.EnvPrefix && !validEnvPrefix(opts.EnvPrefix) {
+		return invalid("EnvPrefix %q isn't made of letters, digits, and underscores", opts.EnvPrefix)
+	}
+	strategy := Strategy
+	if Single != opts.Strategy {
+		strategy = opts.Strategy
+	}
+	switch strategy {
+	case Single, Supervised, InPlace, ReusePort:
+	case Double:
+		return invalid("the Upgrader doesn't support the Double strategy")
+	default:
+		return invalid("unknown strategy %d", strategy)
+	}
+	for name, d := range map[string]time.Duration{
+		"ReadyTimeout":       opts.ReadyTimeout,
+		"RestartWhenIdle":    opts.RestartWhenIdle,
+		"Overlap":            opts.Overlap,
+		"GraceDeadline":      opts.GraceDeadline,
+		"MinRestartInterval": opts.MinRestartInterval,
+	} {
+		if 0 > d {
+			return invalid("%s is negative", name)
+		}
+	}
+	if 0 > opts.MaxRestartFailures {
+		return invalid("MaxRestartFailures is negative")
+	}
+	if Supervised == strategy || InPlace == strategy {
+		if 0 != opts.ReadyTimeout || 0 != opts.Overlap {
+			return invalid("ReadyTimeout and Overlap need a child but the strategy spawns none")
+		}
+	}
+	if 0 != opts.GraceDeadline && 0 != opts.Overlap && opts.GraceDeadline <= opts.Overlap {
+		return invalid("GraceDeadline %v would cut short Overlap %v", opts.GraceDeadline, opts.Overlap)
+	}
+	switch opts.ReadySignal {
+	case 0, syscall.SIGQUIT:
+	case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, sigUSR1, sigUSR2:
+		return invalid("ReadySignal %v already means something else", opts.ReadySignal)
+	}
+	if uncatchable(opts.ReadySignal) {
+		return invalid("ReadySignal %v can't be caught", opts.ReadySignal)
+	}
+	for from, to := range opts.SignalMap {
+		if uncatchable(from) {
+			return invalid("SignalMap remaps %v, which can't be caught", from)
+		}
+		if 0 != opts.ReadySignal && opts.ReadySignal == to {
+			return invalid("SignalMap maps %v to ReadySignal %v", from, to)
+		}
+	}
+	if nil != opts.Args && 0 == len(opts.Args) {
+		return invalid("Args is empty but must start with argv[0]")
+	}
+	if "" != opts.Executable {
+		if fi, err := os.Stat(opts.Executable); nil != err {
+			return invalid("Executable: %v", err)
+		} else if fi.IsDir() || 0 == fi.Mode()&0111 {
+			return invalid("Executable %s isn't executable", opts.Executable)
+		}
+	}
+	if "" != opts.Dir {
+		if fi, err := os.Stat(opts.Dir); nil != err {
+			return invalid("Dir: %v", err)
+		} else if !fi.IsDir() {
+			return invalid("Dir %s isn't a directory", opts.Dir)
+		}
+	}
+	if opts.State && !canInherit {
+		return invalid("State needs file descriptors to be inherited, which this platform doesn't support")
+	}
+	return nil
+}
+
+// Set the package-level variables the options name.
+func (opts *Options) apply() {
+	if nil != opts.Logger {
+		Log = opts.Logger
+	}
+	if "" != opts.EnvPrefix {
+		SetEnvPrefix(opts.EnvPrefix)
+	}
+	if Single != opts.Strategy {
+		Strategy = opts.Strategy
+	}
+	if 0 != opts.ReadySignal {
+		ReadySignal = opts.ReadySignal
+	}
+	if nil != opts.SignalMap {
+		SignalMap = opts.SignalMap
+	}
+	for _, d := range []struct {
+		v   *time.Duration
+		opt time.Duration
+	}{
+		{&ReadyTimeout, opts.ReadyTimeout},
+		{&RestartWhenIdle, opts.RestartWhenIdle},
+		{&Overlap, opts.Overlap},
+		{&GraceDeadline, opts.GraceDeadline},
+		{&MinRestartInterval, opts.MinRestartInterval},
+	} {
+		if 0 != d.opt {
+			*d.v = d.opt
+		}
+	}
+	if 0 != opts.MaxRestartFailures {
+		MaxRestartFailures = opts.MaxRestartFailures
+	}
+	for _, s := range []struct {
+		v   *string
+		opt string
+	}{
+		{&Executable, opts.Executable},
+		{&Dir, opts.Dir},
+		{&PIDFile, opts.PIDFile},
+	} {
+		if "" != s.opt {
+			*s.v = s.opt
+		}
+	}
+	if nil != opts.Args {
+		Args = opts.Args
+	}
+	if nil != opts.PreReady {
+		PreReady = opts.PreReady
+	}
+	if nil != opts.OnBeforeExec {
+		OnBeforeExec = opts.OnBeforeExec
+	}
+	if nil != opts.OnChildSpawned {
+		OnChildSpawned = opts.OnChildSpawned
+	}
+	if nil != opts.OnParentExit {
+		OnParentExit = opts.OnParentExit
+	}
+	if nil != opts.PreHandoff {
+		PreHandoff = opts.PreHandoff
+	}
+	if nil != opts.OnHookError {
+		OnHookError = opts.OnHookError
+	}
+}
+
+func validEnvPrefix(prefix string) bool {
+	return "" == strings.Trim(
+		prefix,
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_",
+	)
+}
@@ -0,0 +1,92 @@
+package goagain
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+type orphanPolicy int
+
+const (
+	// OrphanStay keeps an orphaned child running.  Its Kill returns
+	// ErrParentMismatch so the caller decides what to do.
+	OrphanStay orphanPolicy = iota
+
+	// OrphanExit makes an orphaned child exit with status 1 right away, so
+	// it doesn't serve alongside whatever its supervisor starts in place
+	// of the parent.
+	OrphanExit
+
+	// OrphanTakeOver makes an orphaned child carry on as if its parent had
+	// let go: its Kill succeeds without signaling anybody and it serves
+	// the listeners it inherited.
+	OrphanTakeOver
+)
+
+// These are read without synchronization so set them before calling Wait.
+var (
+	// OrphanPolicy decides what a child does if its parent dies, killed
+	// by SIGKILL or the OOM killer, say, before the child has said it's
+	// ready to take over.  A child watches its parent from the moment it
+	// starts until its Kill succeeds, rather than rely on Linux's
+	// PR_SET_PDEATHSIG, which fires when the thread that spawned the
+	// child exits (however busy the parent still is) and would outlive
+	// the handoff.
+	OrphanPolicy = OrphanStay
+
+	// OnOrphaned, if not nil, is called with the PID of a child's parent
+	// once it's died before the child took over, before OrphanPolicy is
+	// applied, to raise an alert, say.
+	OnOrphaned func(ppid int)
+)
+
+// Whether this process has told its parent to let go, which ends the watch
+// on the parent, guarded by mu.
+var letGo bool
+
+var orphanOnce sync.Once
+
+// Watch the parent process, if this process is a child that inherited its
+// listeners and the parent's still its parent, until Kill succeeds.
+func loadOrphan() {
+	var ppid int
+	if "" != os.Getenv(envKey("PID")) {
+		return
+	}
+	if _, err := fmt.Sscan(os.Getenv(envKey("PPID")), &ppid); nil != err {
+		return
+	}
+	if syscall.Getppid() != ppid {
+		return
+	}
+	orphanOnce.Do(func() {
+		go watchParent(ppid)
+	})
+}
+
+func watchParent(ppid int) {
+	if err := awaitExit(context.Background(), ppid); nil != err {
+		logln("watching parent", ppid, err)
+		return
+	}
+	mu.Lock()
+	done := letGo
+	mu.Unlock()
+	if done {
+		return
+	}
+	event(
+		"orphaned",
+		[]interface{}{"parent", ppid},
+		"parent", ppid, "died before this process took over",
+	)
+	if nil != conf().OnOrphaned {
+		conf().OnOrphaned(ppid)
+	}
+	if OrphanExit == conf().OrphanPolicy {
+		os.Exit(1)
+	}
+}
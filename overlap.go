@@ -0,0 +1,46 @@
+package goagain
+
+import (
+	"os"
+	"time"
+)
+
+// Overlap, if not zero, is how long this process keeps accepting connections
+// alongside a child that's said it's ready, both taking connections from the
+// listeners they share, before Wait returns so it can stop accepting and
+// drain.  This avoids a dip in throughput while the child warms up.  Set it
+// before calling Wait.
+var Overlap time.Duration
+
+// Whether an overlap is under way and whether it's over, guarded by mu.
+var overlapping, overlapped bool
+
+// Start the overlap once the child says it's ready, if there's to be one,
+// and report whether the handoff must therefore wait for it, after which the
+// child's ready signal is fed to Wait again.  Call with mu held.
+func startOverlap(sig os.Signal) bool {
+	if overlapped {
+		overlapping, overlapped = false, false
+		return false
+	}
+	if 0 == conf().Overlap || overlapping || nil == child {
+		return overlapping
+	}
+	overlapping = true
+	p := child
+	event(
+		"overlap",
+		[]interface{}{"child", p.Pid, "duration", conf().Overlap.String()},
+		"accepting alongside child", p.Pid, "for", conf().Overlap,
+	)
+	time.AfterFunc(conf().Overlap, func() {
+		mu.Lock()
+		current := child == p
+		overlapping, overlapped = false, current
+		mu.Unlock()
+		if current {
+			InjectSignal(sig)
+		}
+	})
+	return true
+}
@@ -0,0 +1,127 @@
+package goagain
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Datagram sockets handed to the new process on restart, in the order they
+// were added, and those inherited from the parent process not yet taken by
+// ListenPacket or PacketConns, guarded by mu.
+var (
+	packetConns      []net.PacketConn
+	inheritedPackets []net.PacketConn
+	packetErr        error
+	packetOnce       sync.Once
+)
+
+// Hand the given datagram socket, such as a *net.UDPConn, to the new process
+// on restart so it keeps its port without racing to bind it again.  The
+// child recovers it with PacketConns or ListenPacket.
+func AddPacketConn(c net.PacketConn) {
+	mu.Lock()
+	defer mu.Unlock()
+	packetConns = append(packetConns, c)
+}
+
+// Return the datagram socket inherited from the parent process on the given
+// network and address, if there is one, or else a fresh one, and hand it on
+// at the next restart, too.  ListenPacket may be called once per address.
+func ListenPacket(network, addr string) (net.PacketConn, error) {
+	if err := loadPacketConns(); nil != err {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, c := range inheritedPackets {
+		if sameAddr(c.LocalAddr(), network, addr) {
+			inheritedPackets = append(inheritedPackets[:i], inheritedPackets[i+1:]...)
+			packetConns = append(packetConns, c)
+			return c, nil
+		}
+	}
+	c, err := ListenConfig().ListenPacket(context.Background(), network, addr)
+	if nil != err {
+		return nil, err
+	}
+	packetConns = append(packetConns, c)
+	return c, nil
+}
+
+// Return every datagram socket inherited from the parent process and not yet
+// taken by ListenPacket, in the order they were added there, and hand them on
+// at the next restart, too.
+func PacketConns() ([]net.PacketConn, error) {
+	if err := loadPacketConns(); nil != err {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	cs := inheritedPackets
+	inheritedPackets = nil
+	packetConns = append(packetConns, cs...)
+	return cs, nil
+}
+
+// Reconstruct the datagram sockets inherited from the parent process or
+// passed by systemd socket activation, if any, the first time this is
+// called.
+func loadPacketConns() error {
+	if err := loadInherited(); nil != err {
+		return err
+	}
+	packetOnce.Do(func() {
+		cs, err := inheritPacketConns()
+		mu.Lock()
+		defer mu.Unlock()
+		inheritedPackets, packetErr = append(inheritedPackets, cs...), err
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	return packetErr
+}
+
+func inheritPacketConns() ([]net.PacketConn, error) {
+	manifest, err := Manifest()
+	if nil != err {
+		return nil, err
+	}
+	fds := make(map[int]uintptr)
+	var indices []int
+	for purpose, fd := range manifest {
+		if !strings.HasPrefix(purpose, "packet/") {
+			continue
+		}
+		var i int
+		if _, err := fmt.Sscan(purpose[len("packet/"):], &i); nil != err {
+			return nil, fmt.Errorf("malformed manifest entry %q", purpose)
+		}
+		fds[i] = fd
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	cs := make([]net.PacketConn, 0, len(indices))
+	for _, i := range indices {
+		c, err := filePacketConn(fds[i], fmt.Sprintf("packet/%d", i))
+		if nil != err {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+// Duplicate the file descriptor underlying a datagram socket for the new
+// process.
+func packetConnFD(c net.PacketConn) (int, error) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return -1, fmt.Errorf("%T has no file descriptor", c)
+	}
+	return dupConn(sc)
+}
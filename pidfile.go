@@ -0,0 +1,111 @@
+package goagain
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// PIDFile, if not empty, is the name of a file kept holding the PID of the
+// process serving, as Nginx and Unicorn keep theirs.  Wait and Master.Run
+// write it.  While a child is in flight it's renamed with the suffix
+// ".oldbin" so the child can write its own and it's renamed back if the
+// child fails to take over.  RemovePIDFile removes whichever of the two
+// holds this process' PID.  Set it before calling Wait.  It defaults to
+// GOAGAIN_PIDFILE from the environment, if that's set.
+var PIDFile string
+
+func loadPIDFile() {
+	if name := os.Getenv(envKey("PIDFILE")); "" != name {
+		PIDFile = name
+	}
+}
+
+// Remove PIDFile or PIDFile.oldbin, whichever holds this process' PID, as
+// the process exits.  Exit calls it; others should defer it in main.
+func RemovePIDFile() error {
+	if "" == conf().PIDFile {
+		return nil
+	}
+	for _, name := range []string{conf().PIDFile, oldPIDFile()} {
+		if !holdsPID(name) {
+			continue
+		}
+		if err := os.Remove(name); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return whether the named file holds this process' PID.
+func holdsPID(name string) bool {
+	b, err := os.ReadFile(name)
+	if nil != err {
+		return false
+	}
+	return strconv.Itoa(os.Getpid()) == string(bytes.TrimSpace(b))
+}
+
+func oldPIDFile() string {
+	return conf().PIDFile + ".oldbin"
+}
+
+// Rename PIDFile to PIDFile.oldbin now that a child is in flight.  Call with
+// mu held.
+func renamePIDFile() {
+	if "" == conf().PIDFile || !holdsPID(conf().PIDFile) {
+		return
+	}
+	if err := os.Rename(conf().PIDFile, oldPIDFile()); nil != err {
+		logln("renaming PID file", err)
+	}
+}
+
+// Rename PIDFile.oldbin back to PIDFile now that the child in flight has
+// failed.  Call with mu held.
+func restorePIDFile() {
+	if "" == conf().PIDFile || !holdsPID(oldPIDFile()) {
+		return
+	}
+	if err := os.Rename(oldPIDFile(), conf().PIDFile); nil != err {
+		logln("restoring PID file", err)
+	}
+}
+
+// Write this process' PID to PIDFile, by way of a temporary file so nobody
+// reads half of it, and remove PIDFile.oldbin if it holds this process' PID,
+// as it does after an Exec.
+func writePIDFile() error {
+	if "" == conf().PIDFile {
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(conf().PIDFile), filepath.Base(conf().PIDFile)+".*")
+	if nil != err {
+		return err
+	}
+	if _, err = fmt.Fprintln(f, os.Getpid()); nil != err {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Chmod(0644); nil != err {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); nil != err {
+		os.Remove(f.Name())
+		return err
+	}
+	if err = os.Rename(f.Name(), conf().PIDFile); nil != err {
+		os.Remove(f.Name())
+		return err
+	}
+	if holdsPID(oldPIDFile()) {
+		return os.Remove(oldPIDFile())
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package goagain
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Scheduling priority is inherited across fork and exec anyway but a parent
+// records its own in the environment and a child restores it here in case
+// something in between (a wrapper script, say) changed it.
+func loadPriority() {
+	var nice int
+	if _, err := fmt.Sscan(os.Getenv(envKey("PRIORITY")), &nice); nil != err {
+		return
+	}
+	if current, err := getNice(); nil == err && current == nice {
+		return
+	}
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice); nil != err {
+		logln("restoring priority", nice, err)
+	}
+}
+
+func getNice() (int, error) {
+
+	// The raw system call returns 20 minus the nice value to avoid
+	// returning negative numbers.
+	prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if nil != err {
+		return 0, err
+	}
+	return 20 - prio, nil
+}
+
+func setPriorityEnv(v envVars) error {
+	nice, err := getNice()
+	if nil != err {
+		return err
+	}
+	v.set("PRIORITY", fmt.Sprint(nice))
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package goagain
+
+// Scheduling priority is inherited across fork and exec so there's nothing
+// more to do here.
+func loadPriority() {}
+
+func setPriorityEnv(v envVars) error {
+	return nil
+}
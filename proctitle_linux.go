@@ -0,0 +1,90 @@
+package goagain
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// prctl(2) options for changing where the kernel finds the arguments it
+// reports in /proc/self/cmdline.
+const (
+	prSetMM         = 35
+	prSetMMArgStart = 8
+	prSetMMArgEnd   = 9
+)
+
+// Every argument list SetProcTitle has pointed the kernel at, for
+// /proc/self/cmdline to report, kept here so none is ever freed.
+var titleArgs [][]byte
+
+// Set the title ps and top show for this process.  The command name, which
+// top and ps -o comm show, is truncated to 15 bytes.  The command line, which
+// ps -f and ps aux show, is replaced only if this process may change it (it
+// needs CAP_SYS_RESOURCE) because the arguments Go was started with are
+// shared with os.Args and can't safely be overwritten.
+func SetProcTitle(title string) error {
+	comm := title
+	if 15 < len(comm) {
+		comm = comm[:15]
+	}
+	if err := os.WriteFile("/proc/self/comm", []byte(comm), 0); nil != err {
+		return err
+	}
+	b := append([]byte(title), 0)
+	start := uintptr(unsafe.Pointer(&b[0]))
+	end := start + uintptr(len(b))
+	mu.Lock()
+	titleArgs = append(titleArgs, b)
+	mu.Unlock()
+
+	// The kernel insists the start never be past the end, even for a
+	// moment, so move whichever end keeps that true first.
+	first, second := [2]uintptr{prSetMMArgStart, start}, [2]uintptr{prSetMMArgEnd, end}
+	if start >= currentArgEnd() {
+		first, second = second, first
+	}
+	for _, opt := range [][2]uintptr{first, second} {
+		if _, _, errno := syscall.RawSyscall6(
+			syscall.SYS_PRCTL,
+			prSetMM,
+			opt[0],
+			opt[1],
+			0, 0, 0,
+		); 0 != errno {
+			if syscall.EPERM == errno || syscall.EINVAL == errno {
+				return nil // The command name will have to do.
+			}
+			return errno
+		}
+	}
+	return nil
+}
+
+// Return where the kernel last found this process' arguments ending.
+func currentArgEnd() uintptr {
+	b, err := os.ReadFile("/proc/self/stat")
+	if nil != err {
+		return 0
+	}
+
+	// Skip past the command name, which may contain anything, to the
+	// fields after it, of which arg_end is the 49th overall.
+	i := len(b) - 1
+	for 0 < i && ')' != b[i] {
+		i--
+	}
+	var (
+		field int
+		end   uintptr
+	)
+	for _, c := range b[i+1:] {
+		switch {
+		case ' ' == c:
+			field++
+		case 47 == field && '0' <= c && '9' >= c:
+			end = end*10 + uintptr(c-'0')
+		}
+	}
+	return end
+}
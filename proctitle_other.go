@@ -0,0 +1,10 @@
+//go:build !linux
+
+package goagain
+
+import "errors"
+
+// Return an error since only Linux lets a Go program change its title.
+func SetProcTitle(title string) error {
+	return errors.New("goagain: can't set the process title on this platform")
+}
@@ -0,0 +1,415 @@
+package goagain
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A QUICConn is a datagram socket that a QUIC server, such as one serving
+// HTTP/3, reads and writes in place of the *net.UDPConn it wraps.  During a
+// restart, parent and child share the socket so the kernel hands each packet
+// to whichever reads it first, which would break every connection that
+// lands in the wrong process.  So the two route packets to one another by
+// connection ID over a Unix socket: a packet for a connection ID from
+// QUICConnectionID goes to the generation that issued it and a packet that
+// opens a new connection goes to the child once it's taken over, and to the
+// parent until then.  The server must issue only connection IDs made by
+// QUICConnectionID and must not reach around the QUICConn to the socket.
+type QUICConn struct {
+	net.PacketConn
+
+	in     chan quicPacket
+	closed chan struct{}
+	once   sync.Once
+
+	// The read deadline and a channel closed when it changes, guarded by
+	// deadlineMu.
+	deadlineMu sync.Mutex
+	deadline   time.Time
+	changed    chan struct{}
+
+	// This process' ends of the sockets to the parent and the child, if
+	// they're running, guarded by peerMu.
+	peerMu        sync.Mutex
+	parent, child *net.UnixConn
+}
+
+// A packet read from the socket or forwarded by the other generation.
+type quicPacket struct {
+	b    []byte
+	addr net.Addr
+	err  error
+}
+
+// The QUICConns to be handed to the new process on restart, guarded by mu.
+var quicConns []*QUICConn
+
+// Return a QUICConn on the datagram socket inherited from the parent process
+// on the given network and address, if there is one, or else a fresh one, as
+// ListenPacket does.  ListenQUIC may be called once per address.
+func ListenQUIC(network, addr string) (*QUICConn, error) {
+	c, err := ListenPacket(network, addr)
+	if nil != err {
+		return nil, err
+	}
+	qc := &QUICConn{
+		PacketConn: c,
+		in:         make(chan quicPacket, 1024),
+		closed:     make(chan struct{}),
+		changed:    make(chan struct{}),
+	}
+	if parent, err := inheritQUIC(c.LocalAddr()); nil != err {
+		logln("routing QUIC packets to the parent", err)
+	} else if nil != parent {
+		qc.parent = parent
+		go qc.readPeer(parent)
+	}
+	mu.Lock()
+	quicConns = append(quicConns, qc)
+	mu.Unlock()
+	go qc.readSocket()
+	return qc, nil
+}
+
+// Return a random QUIC connection ID n bytes long, which must be at least 1,
+// that routes packets for it to this generation.  Its first byte is this
+// generation's tag.  Give it to a QUIC library's connection ID generator,
+// such as quic-go's ConnectionIDGenerator.
+func QUICConnectionID(n int) ([]byte, error) {
+	if 1 > n {
+		return nil, errors.New("goagain: QUIC connection ID too short to route")
+	}
+	b := make([]byte, n)
+	if _, err := rand.Read(b[1:]); nil != err {
+		return nil, err
+	}
+	b[0] = quicTag()
+	return b, nil
+}
+
+// Read a packet routed to this process.
+func (qc *QUICConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		qc.deadlineMu.Lock()
+		deadline, changed := qc.deadline, qc.changed
+		qc.deadlineMu.Unlock()
+		var (
+			t       *time.Timer
+			timeout <-chan time.Time
+		)
+		if !deadline.IsZero() {
+			t = time.NewTimer(time.Until(deadline))
+			timeout = t.C
+		}
+		var (
+			p   quicPacket
+			ok  bool
+			err error
+		)
+		select {
+		case p = <-qc.in:
+			ok = true
+		case <-qc.closed:
+			err = net.ErrClosed
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+		case <-changed:
+		}
+		if nil != t {
+			t.Stop()
+		}
+		if ok {
+			return copy(b, p.b), p.addr, p.err
+		}
+		if nil != err {
+			return 0, nil, err
+		}
+	}
+}
+
+// Stop routing packets and close the socket.
+func (qc *QUICConn) Close() error {
+	qc.once.Do(func() {
+		close(qc.closed)
+		qc.peerMu.Lock()
+		for _, uc := range []*net.UnixConn{qc.parent, qc.child} {
+			if nil != uc {
+				uc.Close()
+			}
+		}
+		qc.parent, qc.child = nil, nil
+		qc.peerMu.Unlock()
+	})
+	return qc.PacketConn.Close()
+}
+
+// Set the read and write deadlines.
+func (qc *QUICConn) SetDeadline(t time.Time) error {
+	qc.SetReadDeadline(t)
+	return qc.PacketConn.SetWriteDeadline(t)
+}
+
+// Set the read deadline, which is kept here since the socket is read by a
+// goroutine of its own.
+func (qc *QUICConn) SetReadDeadline(t time.Time) error {
+	qc.deadlineMu.Lock()
+	defer qc.deadlineMu.Unlock()
+	qc.deadline = t
+	close(qc.changed)
+	qc.changed = make(chan struct{})
+	return nil
+}
+
+func (qc *QUICConn) readSocket() {
+	b := make([]byte, 65536)
+	for {
+		n, addr, err := qc.PacketConn.ReadFrom(b)
+		if nil != err && IsErrClosing(err) {
+			return
+		}
+		if nil == err && qc.forward(b[:n], addr) {
+			continue
+		}
+		select {
+		case qc.in <- quicPacket{b: append([]byte{}, b[:n]...), addr: addr, err: err}:
+		case <-qc.closed:
+			return
+		}
+	}
+}
+
+func (qc *QUICConn) readPeer(uc *net.UnixConn) {
+	b := make([]byte, 65536)
+	for {
+		n, err := uc.Read(b)
+		if nil != err {
+			qc.dropPeer(uc, err)
+			return
+		}
+		p, ok := decodeQUICPacket(b[:n])
+		if !ok {
+			continue
+		}
+		select {
+		case qc.in <- p:
+		case <-qc.closed:
+			return
+		}
+	}
+}
+
+// Forward a packet to the other generation if it's that generation's, and
+// report whether it was forwarded.
+func (qc *QUICConn) forward(b []byte, addr net.Addr) bool {
+	qc.peerMu.Lock()
+	parent, child := qc.parent, qc.child
+	qc.peerMu.Unlock()
+	if nil == parent && nil == child {
+		return false
+	}
+	tag, initial, ok := quicRoute(b)
+	if !ok {
+		return false
+	}
+	var uc *net.UnixConn
+	if initial {
+		mu.Lock()
+		h, l := handedOff, letGo
+		mu.Unlock()
+		if h {
+			uc = child
+		} else if !l {
+			uc = parent
+		}
+	} else if mine := quicTag(); mine-1 == tag {
+		uc = parent
+	} else if mine+1 == tag {
+		uc = child
+	}
+	if nil == uc {
+		return false
+	}
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	if _, err := uc.Write(encodeQUICPacket(b, ua)); nil != err {
+		qc.dropPeer(uc, err)
+		return false
+	}
+	return true
+}
+
+// Stop routing packets to a generation that's gone.
+func (qc *QUICConn) dropPeer(uc *net.UnixConn, err error) {
+	qc.peerMu.Lock()
+	defer qc.peerMu.Unlock()
+	if qc.parent == uc {
+		qc.parent = nil
+	} else if qc.child == uc {
+		qc.child = nil
+	} else {
+		return
+	}
+	uc.Close()
+	if !IsErrClosing(err) {
+		logln("routing QUIC packets", err)
+	}
+}
+
+// Give the child about to be spawned a socket over which to route packets to
+// and from this process for each QUICConn, replacing any opened for an
+// earlier child, and return the child's ends, which are the caller's to close
+// once the child's spawned.  Call with mu held.
+func openQUIC() ([]*os.File, error) {
+	if !canInherit {
+		return nil, nil
+	}
+	var fs []*os.File
+	for _, qc := range quicConns {
+		ours, theirs, err := quicPair()
+		if nil != err {
+			closeQUIC(fs, false)
+			return nil, err
+		}
+		qc.setChild(ours)
+		extraFiles[quicPurpose(qc.LocalAddr())] = theirs
+		fs = append(fs, theirs)
+	}
+	return fs, nil
+}
+
+// Close the child's ends of the sockets now that the child's been spawned, or
+// the whole sockets if it hasn't.  Call with mu held.
+func closeQUIC(fs []*os.File, spawned bool) {
+	for _, qc := range quicConns {
+		delete(extraFiles, quicPurpose(qc.LocalAddr()))
+		if !spawned {
+			qc.setChild(nil)
+		}
+	}
+	for _, f := range fs {
+		f.Close()
+	}
+}
+
+func (qc *QUICConn) setChild(uc *net.UnixConn) {
+	qc.peerMu.Lock()
+	old := qc.child
+	qc.child = uc
+	qc.peerMu.Unlock()
+	if nil != old {
+		old.Close()
+	}
+	if nil != uc {
+		go qc.readPeer(uc)
+	}
+}
+
+// Return the socket inherited from the parent process over which to route
+// packets for the QUICConn at the given address, if there is one.
+func inheritQUIC(addr net.Addr) (*net.UnixConn, error) {
+	manifest, err := Manifest()
+	if nil != err {
+		return nil, err
+	}
+	fd, ok := manifest[quicPurpose(addr)]
+	if !ok {
+		return nil, nil
+	}
+	closeOnExec(fd)
+	f := os.NewFile(fd, "quic")
+	c, err := net.FileConn(f)
+	f.Close()
+	if nil != err {
+		return nil, err
+	}
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		c.Close()
+		return nil, errors.New("inherited something other than a Unix socket")
+	}
+	return uc, nil
+}
+
+func quicPurpose(addr net.Addr) string {
+	return "quic/" + addr.String()
+}
+
+// This generation's tag, the first byte of every connection ID it issues.
+func quicTag() byte {
+	return byte(Generation())
+}
+
+// Return the first byte of the connection ID a QUIC packet is addressed to
+// and whether the packet opens a new connection, as an Initial or 0-RTT
+// packet does with a connection ID the client chose.
+func quicRoute(b []byte) (tag byte, initial, ok bool) {
+	if 0 == len(b) {
+		return 0, false, false
+	}
+
+	// A short header is followed directly by the connection ID.
+	if 0 == b[0]&0x80 {
+		if 2 > len(b) {
+			return 0, false, false
+		}
+		return b[1], false, true
+	}
+
+	// A long header names the version and the length of the connection
+	// ID, and its type says whether it opens a connection.
+	if 6 > len(b) {
+		return 0, false, false
+	}
+	typ := (b[0] & 0x30) >> 4
+	switch binary.BigEndian.Uint32(b[1:5]) {
+	case 0:
+		return 0, false, false // version negotiation, sent only to clients
+	case 1:
+		initial = 0 == typ || 1 == typ
+	case 0x6b3343cf: // QUIC version 2, which renumbers the types
+		initial = 1 == typ || 2 == typ
+	default:
+		initial = true // an unknown version, as a client opens with
+	}
+	if initial {
+		return 0, true, true
+	}
+	if 0 == b[5] || 6 == len(b) {
+		return 0, false, false
+	}
+	return b[6], false, true
+}
+
+// Encode a packet and the client's address to route it to the other
+// generation: the length of the IP address, the address, the port, and the
+// packet.
+func encodeQUICPacket(b []byte, addr *net.UDPAddr) []byte {
+	ip := addr.IP
+	if ip4 := ip.To4(); nil != ip4 {
+		ip = ip4
+	}
+	out := make([]byte, 0, 1+len(ip)+2+len(b))
+	out = append(out, byte(len(ip)))
+	out = append(out, ip...)
+	out = binary.BigEndian.AppendUint16(out, uint16(addr.Port))
+	return append(out, b...)
+}
+
+func decodeQUICPacket(b []byte) (quicPacket, bool) {
+	if 1 > len(b) || len(b) < 1+int(b[0])+2 {
+		return quicPacket{}, false
+	}
+	n := int(b[0])
+	addr := &net.UDPAddr{
+		IP:   append(net.IP(nil), b[1:1+n]...),
+		Port: int(binary.BigEndian.Uint16(b[1+n:])),
+	}
+	return quicPacket{b: append([]byte{}, b[1+n+2:]...), addr: addr}, true
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package goagain
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// Return a connected pair of Unix datagram sockets: this process' end and
+// the child's, to be inherited.
+func quicPair() (*net.UnixConn, *os.File, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if nil != err {
+		return nil, nil, err
+	}
+	syscall.CloseOnExec(fds[0])
+	f := os.NewFile(uintptr(fds[0]), "quic")
+	c, err := net.FileConn(f)
+	f.Close()
+	if nil != err {
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+	return c.(*net.UnixConn), os.NewFile(uintptr(fds[1]), "quic"), nil
+}
@@ -0,0 +1,11 @@
+package goagain
+
+import (
+	"net"
+	"os"
+)
+
+// Return an error since Windows processes can't inherit sockets.
+func quicPair() (*net.UnixConn, *os.File, error) {
+	return nil, nil, errNoInherit
+}
@@ -0,0 +1,40 @@
+package goagain
+
+import (
+	"math/rand"
+	"syscall"
+	"time"
+)
+
+// Restart this process every interval, give or take up to jitter, to bound
+// its age, as for slow memory growth.  Each restart is triggered by sending
+// this process SIGUSR2 so it goes through Wait like any other; one is
+// skipped if a child is already in flight.  Call the returned function to
+// stop.
+func Recycle(interval, jitter time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			d := interval
+			if 0 < jitter {
+				d += time.Duration(rand.Int63n(int64(2*jitter))) - jitter
+			}
+			t := time.NewTimer(d)
+			select {
+			case <-done:
+				t.Stop()
+				return
+			case <-t.C:
+			}
+			if nil != getChild() {
+				logln("not recycling while a child is in flight")
+				continue
+			}
+			logln("recycling after", d)
+			if err := kill(syscall.Getpid(), sigUSR2); nil != err {
+				logln("recycling:", err)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
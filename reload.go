@@ -0,0 +1,25 @@
+package goagain
+
+// Functions registered with HandleSIGHUP, guarded by mu.
+var reloaders []func() error
+
+// Register a function for Wait to call on SIGHUP, after OnSIGHUP and every
+// Manager's OnSIGHUP, to reload configuration in place.  Functions are called
+// in the order they were registered; one failing doesn't stop the rest and
+// its error is passed to OnHookError.
+func HandleSIGHUP(f func() error) {
+	mu.Lock()
+	defer mu.Unlock()
+	reloaders = append(reloaders, f)
+}
+
+func reload() {
+	mu.Lock()
+	fs := append([]func() error(nil), reloaders...)
+	mu.Unlock()
+	for _, f := range fs {
+		if err := f(); nil != err {
+			hookError("HandleSIGHUP", err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package goagain
+
+import "errors"
+
+// ErrRelaunchInProgress is returned by Restart and ForkExec when a child
+// process spawned earlier hasn't yet taken over or given up, so only one
+// restart is ever in flight.
+var ErrRelaunchInProgress = errors.New("goagain: relaunch already in progress")
+
+// A request fed to Wait by Restart or RestartCommand: where to report the
+// outcome and, from RestartCommand, the program to run.
+type restartRequest struct {
+	reply   chan<- error
+	command []string
+}
+
+// Restart requests fed to Wait.
+var restarts = make(chan restartRequest)
+
+// Where to report the outcome of the Restart in progress, if any, guarded by
+// mu.
+var restartReply chan<- error
+
+// The program and arguments given to RestartCommand for the restart in
+// progress, until the child's spawned or this process re-executes, guarded
+// by mu.
+var restartCommand []string
+
+// Restart as though Wait, which must be running in another goroutine, had
+// received SIGUSR2, regardless of SignalMap, and block until the child
+// process has taken over or failed to.  The error says why the restart
+// failed, if it did; a *RelaunchError names the child that failed.  Wait
+// returns, as usual, once the child has taken over.
+func Restart() error {
+	return restart(nil)
+}
+
+// Restart as Restart does but run the program at the given path, with the
+// given arguments following argv[0], in place of Executable and Args for
+// this restart only, to hand the listeners to an entirely different program,
+// such as service-v2 taking over from service-v1.  The program needn't be
+// written in Go so long as it speaks the protocol described in the README;
+// under the InPlace strategy, it's the program Exec runs next.
+func RestartCommand(path string, args ...string) error {
+	if "" == path {
+		return errors.New("goagain: no program to restart")
+	}
+	return restart(append([]string{path}, args...))
+}
+
+func restart(command []string) error {
+	reply := make(chan error, 1)
+	restarts <- restartRequest{reply, command}
+	return <-reply
+}
+
+// Report the outcome of the Restart in progress, if any.
+func finishRestart(err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	finishRestartLocked(err)
+}
+
+func finishRestartLocked(err error) {
+	statusUpgrade(err)
+	if nil == restartReply {
+		return
+	}
+	restartReply <- err
+	restartReply = nil
+}
+
+// Begin a Restart unless a child is already in flight.
+func startRestart(req restartRequest) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if nil != child {
+		req.reply <- ErrRelaunchInProgress
+		return false
+	}
+	restartReply, restartCommand = req.reply, req.command
+	return true
+}
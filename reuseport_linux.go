@@ -0,0 +1,4 @@
+package goagain
+
+// SO_REUSEPORT, which package syscall doesn't define on Linux.
+const soReusePort = 0xf
@@ -0,0 +1,7 @@
+package goagain
+
+import "syscall"
+
+// SO_REUSEADDR on Windows lets two sockets bind the same address, as
+// SO_REUSEPORT does elsewhere.
+const soReusePort = syscall.SO_REUSEADDR
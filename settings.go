@@ -0,0 +1,147 @@
+package goagain
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// The package-level variables that configure goagain, as they were when
+// last settled.  goagain's own goroutines read these rather than the
+// variables, which the caller may be setting meanwhile.
+type settings struct {
+	AuditFile     string
+	AuditRedact   []string
+	Args          []string
+	Checksum      string
+	ChildStdin    *os.File
+	ChildStdout   *os.File
+	ChildStderr   *os.File
+	ChildStreams  func() (stdin, stdout, stderr *os.File, err error)
+	Compatible    func(parent, child BuildInfo) error
+	ConnMigration bool
+	Control       func(network, address string, c syscall.RawConn) error
+	Deregister    func() error
+	Dir           string
+	EnvAllow      []string
+	EnvDeny       []string
+	EnvExtra      []string
+	Events        eventFormat
+	Executable    string
+	GraceDeadline time.Duration
+	Linger        int
+	Log           Logger
+
+	MaxRestartFailures int
+	MinRestartInterval time.Duration
+
+	OnBacklog      func(c net.Conn)
+	OnBeforeExec   func() error
+	OnChildSpawned func(pid int) error
+	OnForceClose   func(c net.Conn)
+	OnHookError    func(name string, err error)
+	OnOrphaned     func(ppid int)
+	OnParentExit   func() error
+	OnSIGHUP       func(l net.Listener) error
+	OnSIGUSR1      func(l net.Listener) error
+	OnThrottle     func(failures int)
+
+	OrphanPolicy    orphanPolicy
+	Overlap         time.Duration
+	PIDFile         string
+	PreHandoff      func(l net.Listener) error
+	PreReady        func() error
+	ProcTitle       func(role string) string
+	ProtocolVersion int
+	ReadySignal     syscall.Signal
+	ReadyTimeout    time.Duration
+	Register        func() error
+	RestartWhenIdle time.Duration
+	SignalMap       map[syscall.Signal]syscall.Signal
+	SocketHandoff   bool
+	SocketOptions   []SocketOption
+	Strategy        strategy
+	SysProcAttr     *syscall.SysProcAttr
+	Version         string
+}
+
+// The settings in effect.
+var active atomic.Pointer[settings]
+
+func init() {
+	active.Store(snapshot())
+}
+
+// Return the settings in effect.
+func conf() *settings {
+	return active.Load()
+}
+
+// Put the package-level variables into effect, unless an Upgrader is in
+// charge, in which case its settings stay in effect.  Exported functions that
+// start something call this first, in the caller's goroutine.
+func settle() {
+	mu.Lock()
+	defer mu.Unlock()
+	if nil == upgrader {
+		active.Store(snapshot())
+	}
+}
+
+// Copy the package-level variables.
+func snapshot() *settings {
+	return &settings{
+		AuditFile:          AuditFile,
+		AuditRedact:        AuditRedact,
+		Args:               Args,
+		Checksum:           Checksum,
+		ChildStdin:         ChildStdin,
+		ChildStdout:        ChildStdout,
+		ChildStderr:        ChildStderr,
+		ChildStreams:       ChildStreams,
+		Compatible:         Compatible,
+		ConnMigration:      ConnMigration,
+		Control:            Control,
+		Deregister:         Deregister,
+		Dir:                Dir,
+		EnvAllow:           EnvAllow,
+		EnvDeny:            EnvDeny,
+		EnvExtra:           EnvExtra,
+		Events:             Events,
+		Executable:         Executable,
+		GraceDeadline:      GraceDeadline,
+		Linger:             Linger,
+		Log:                Log,
+		MaxRestartFailures: MaxRestartFailures,
+		MinRestartInterval: MinRestartInterval,
+		OnBacklog:          OnBacklog,
+		OnBeforeExec:       OnBeforeExec,
+		OnChildSpawned:     OnChildSpawned,
+		OnForceClose:       OnForceClose,
+		OnHookError:        OnHookError,
+		OnOrphaned:         OnOrphaned,
+		OnParentExit:       OnParentExit,
+		OnSIGHUP:           OnSIGHUP,
+		OnSIGUSR1:          OnSIGUSR1,
+		OnThrottle:         OnThrottle,
+		OrphanPolicy:       OrphanPolicy,
+		Overlap:            Overlap,
+		PIDFile:            PIDFile,
+		PreHandoff:         PreHandoff,
+		PreReady:           PreReady,
+		ProcTitle:          ProcTitle,
+		ProtocolVersion:    ProtocolVersion,
+		ReadySignal:        ReadySignal,
+		ReadyTimeout:       ReadyTimeout,
+		Register:           Register,
+		RestartWhenIdle:    RestartWhenIdle,
+		SignalMap:          SignalMap,
+		SocketHandoff:      SocketHandoff,
+		SocketOptions:      SocketOptions,
+		Strategy:           Strategy,
+		SysProcAttr:        SysProcAttr,
+		Version:            Version,
+	}
+}
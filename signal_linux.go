@@ -0,0 +1,12 @@
+package goagain
+
+import "syscall"
+
+// Return the real-time signal SIGRTMIN+n for use as ReadySignal.  Real-time
+// signals are queued rather than coalesced so, unlike SIGUSR1 and SIGUSR2,
+// one can't be lost to another of its kind arriving at the same time.
+func RTSignal(n int) syscall.Signal {
+
+	// The C library reserves the first few real-time signals for itself.
+	return syscall.Signal(34 + n)
+}
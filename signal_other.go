@@ -0,0 +1,11 @@
+//go:build !linux
+
+package goagain
+
+import "syscall"
+
+// Return SIGUSR1 for use as ReadySignal since real-time signals aren't
+// available on this platform.
+func RTSignal(n int) syscall.Signal {
+	return sigUSR1
+}
@@ -0,0 +1,134 @@
+package goagain
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// A SocketOption is an integer socket option as set by setsockopt(2), for
+// example SocketOption{syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT, 1}.
+type SocketOption struct {
+	Level, Name, Value int
+}
+
+// These are read without synchronization so set them before calling Listen.
+var (
+	// SocketOptions are set on every socket Listen, ListenPacket, and
+	// AdoptOrBind bind afresh, before it's bound, so a child that falls
+	// back to binding anew gets the same options as the parent's
+	// inherited sockets.  They're recorded in the environment for the
+	// child, which uses them if it sets none of its own, and set again,
+	// where the kernel allows, on inherited listeners.
+	SocketOptions []SocketOption
+
+	// Control, if not nil, is called with every socket Listen,
+	// ListenPacket, and AdoptOrBind bind afresh, after SocketOptions are
+	// set and before it's bound, as net.ListenConfig's Control is.
+	Control func(network, address string, c syscall.RawConn) error
+)
+
+// Return a net.ListenConfig that sets SocketOptions (and SO_REUSEPORT under
+// the ReusePort strategy) and calls Control on every socket it binds, for
+// binding listeners the way Listen does.
+func ListenConfig() *net.ListenConfig {
+	return &net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			if err := setSocketOptions(c); nil != err {
+				return err
+			}
+			if ReusePort == conf().Strategy {
+				if err := setReusePort(c); nil != err {
+					return err
+				}
+			}
+			if nil != conf().Control {
+				return conf().Control(network, address, c)
+			}
+			return nil
+		},
+	}
+}
+
+// Bind a fresh listener as ListenConfig does.
+func listenFresh(network, addr string) (net.Listener, error) {
+	return ListenConfig().Listen(context.Background(), network, addr)
+}
+
+// Set SocketOptions again on an inherited listener, logging rather than
+// returning errors since the kernel refuses some options once a socket's
+// bound.
+func resetSocketOptions(l net.Listener) {
+	sc, ok := l.(syscall.Conn)
+	if !ok || 0 == len(socketOptions()) {
+		return
+	}
+	rc, err := sc.SyscallConn()
+	if nil == err {
+		err = setSocketOptions(rc)
+	}
+	if nil != err {
+		logln("setting socket options on", l.Addr(), err)
+	}
+}
+
+func setReusePort(c syscall.RawConn) error {
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		serr = setsockoptInt(fd, syscall.SOL_SOCKET, soReusePort, 1)
+	}); nil != err {
+		return err
+	}
+	return serr
+}
+
+func setSocketOptions(c syscall.RawConn) error {
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		for _, o := range socketOptions() {
+			if err := setsockoptInt(fd, o.Level, o.Name, o.Value); nil != err {
+				serr = fmt.Errorf("setsockopt(%d, %d, %d): %v", o.Level, o.Name, o.Value, err)
+				return
+			}
+		}
+	}); nil != err {
+		return err
+	}
+	return serr
+}
+
+// The socket options recorded in the environment by the parent process, read
+// at startup.
+var inheritedSocketOptions []SocketOption
+
+func loadSocketOptions() {
+	inheritedSocketOptions = nil
+	for _, s := range strings.Split(os.Getenv(envKey("SOCKOPTS")), ",") {
+		var o SocketOption
+		if _, err := fmt.Sscanf(s, "%d:%d=%d", &o.Level, &o.Name, &o.Value); nil == err {
+			inheritedSocketOptions = append(inheritedSocketOptions, o)
+		}
+	}
+}
+
+// Return SocketOptions or, if there are none, those recorded in the
+// environment by the parent process.
+func socketOptions() []SocketOption {
+	if 0 != len(conf().SocketOptions) {
+		return conf().SocketOptions
+	}
+	return inheritedSocketOptions
+}
+
+// Format the socket options for the child's environment.
+func formatSocketOptions() string {
+	opts := socketOptions()
+	fields := make([]string, len(opts))
+	for i, o := range opts {
+		fields[i] = fmt.Sprintf("%d:%d=%d", o.Level, o.Name, o.Value)
+	}
+	return strings.Join(fields, ",")
+}
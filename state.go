@@ -0,0 +1,90 @@
+package goagain
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// A pipe from this process to the child it's spawning over which to stream
+// application state, its read end handed to the child as "state".
+type statePipe struct {
+	r, w   *os.File
+	handed bool
+}
+
+// Open a state pipe for the child about to be spawned if the Upgrader asked
+// for one.  Call with mu held.
+func openState() (*statePipe, error) {
+	if nil == upgrader || !upgrader.opts.State {
+		return nil, nil
+	}
+	r, w, err := os.Pipe()
+	if nil != err {
+		return nil, err
+	}
+	extraFiles["state"] = r
+	return &statePipe{r: r, w: w}, nil
+}
+
+// Close this process' copy of the read end and, unless it was handed to the
+// Upgrader, the write end.  Call with mu held.
+func (sp *statePipe) close() {
+	if nil == sp {
+		return
+	}
+	delete(extraFiles, "state")
+	sp.r.Close()
+	if !sp.handed {
+		sp.w.Close()
+	}
+}
+
+// Hand the write end to the Upgrader now that the child's been spawned,
+// replacing (and closing) any that was never taken.  Call with mu held.
+func (sp *statePipe) hand() {
+	if nil == sp {
+		return
+	}
+	for {
+		select {
+		case upgrader.states <- sp.w:
+			sp.handed = true
+			return
+		case w := <-upgrader.states:
+			w.Close()
+		}
+	}
+}
+
+// Return a channel on which, each time a child process is spawned, this
+// process receives the write end of a pipe to it over which to stream
+// application state such as session caches or rate limiter counters.  The
+// child reads it with StateReader.  Close the writer once the state's
+// written; the child won't take over until it's read everything.  The
+// channel only delivers anything if Options.State is set.
+func (u *Upgrader) StateWriter() <-chan io.WriteCloser {
+	return u.states
+}
+
+// Return the read end of the pipe over which the parent process streams
+// application state, if it does.  Read it until EOF before calling Ready.
+// It can be had only once.
+func (u *Upgrader) StateReader() (io.ReadCloser, error) {
+	manifest, err := Manifest()
+	if nil != err {
+		return nil, err
+	}
+	fd, ok := manifest["state"]
+	if !ok {
+		return nil, ErrNotInherited
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if u.stateTaken {
+		return nil, errors.New("goagain: state already read")
+	}
+	u.stateTaken = true
+	closeOnExec(fd)
+	return os.NewFile(fd, "state"), nil
+}
@@ -0,0 +1,140 @@
+package goagain
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats summarizes how long restarts have taken, from spawning the child to
+// handing off to it, over every generation of this process.  The process that
+// carries on after each handoff records it: the child under the Single
+// strategy and the parent under the Double strategy.
+type Stats struct {
+	Count          int
+	Last, Min, Max time.Duration
+
+	// Average is the moving average of the most recent restarts.
+	Average time.Duration
+}
+
+// The number of restarts included in Stats.Average.
+const statsWindow = 10
+
+var (
+	statsMu sync.Mutex
+	stats   Stats
+	recent  []time.Duration
+	spawned time.Time
+)
+
+func loadStats() {
+	statsMu.Lock()
+	stats, recent = Stats{}, nil
+	statsMu.Unlock()
+	parseStats(os.Getenv(envKey("STATS")))
+}
+
+// Return statistics about the duration of restarts.
+func RestartStats() Stats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	return stats
+}
+
+func formatStats() string {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	fields := []string{
+		fmt.Sprint(stats.Count),
+		fmt.Sprint(int64(stats.Min)),
+		fmt.Sprint(int64(stats.Max)),
+	}
+	for _, d := range recent {
+		fields = append(fields, fmt.Sprint(int64(d)))
+	}
+	return strings.Join(fields, " ")
+}
+
+// Note the time a child was spawned and return it for the child's
+// environment.
+func markSpawned() string {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	spawned = time.Now()
+	return fmt.Sprint(spawned.UnixNano())
+}
+
+func parseStats(s string) {
+	var count, min, max int64
+	r := strings.NewReader(s)
+	if _, err := fmt.Fscan(r, &count, &min, &max); nil != err {
+		return
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats.Count = int(count)
+	stats.Min, stats.Max = time.Duration(min), time.Duration(max)
+	for {
+		var d int64
+		if _, err := fmt.Fscan(r, &d); nil != err {
+			break
+		}
+		recent = append(recent, time.Duration(d))
+	}
+	summarize()
+}
+
+func recordHandoff(d time.Duration) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats.Count++
+	if 1 == stats.Count || d < stats.Min {
+		stats.Min = d
+	}
+	if d > stats.Max {
+		stats.Max = d
+	}
+	recent = append(recent, d)
+	if len(recent) > statsWindow {
+		recent = recent[len(recent)-statsWindow:]
+	}
+	summarize()
+}
+
+// Record the handoff to the parent's own child, which was spawned at the time
+// noted by markSpawned.
+func recordSpawned() {
+	statsMu.Lock()
+	t := spawned
+	statsMu.Unlock()
+	if !t.IsZero() {
+		recordHandoff(time.Since(t))
+	}
+}
+
+// Record the handoff to this process, which was spawned at the time given in
+// the environment by its parent.
+func recordSpawnedEnv() {
+	var ns int64
+	if _, err := fmt.Sscan(os.Getenv(envKey("SPAWNED")), &ns); nil != err {
+		return
+	}
+	os.Unsetenv(envKey("SPAWNED"))
+	recordHandoff(time.Since(time.Unix(0, ns)))
+}
+
+func summarize() {
+	if 0 == len(recent) {
+		stats.Last, stats.Average = 0, 0
+		return
+	}
+	var sum time.Duration
+	for _, d := range recent {
+		sum += d
+	}
+	stats.Last = recent[len(recent)-1]
+	stats.Average = sum / time.Duration(len(recent))
+}
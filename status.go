@@ -0,0 +1,162 @@
+package goagain
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// The phases of this process reported by Status.
+const (
+	StatusServing   = "serving"   // serving with no child in flight
+	StatusUpgrading = "upgrading" // serving while a child takes over
+	StatusDraining  = "draining"  // done accepting; finishing connections
+	StatusExiting   = "exiting"   // drained
+)
+
+// A ProcessStatus describes this process' part in the restart protocol, as
+// returned by Status.
+type ProcessStatus struct {
+	Phase             string
+	PID               int
+	Generation        int
+	ParentPid         int
+	ChildPID          int // zero unless Phase is StatusUpgrading
+	StartedAt         time.Time
+	ActiveConnections int
+
+	// DrainingFor is how long ago the drain began, zero unless Phase is
+	// StatusDraining or StatusExiting.
+	DrainingFor time.Duration
+
+	// LastUpgradeError is why the last restart failed or nil if it
+	// succeeded or there hasn't been one.
+	LastUpgradeError error
+}
+
+// When a signal ended Wait, when the first drain began and whether one has
+// finished, and how the last restart ended, guarded by statusMu.
+var (
+	statusMu       sync.Mutex
+	shutdownAt     time.Time
+	drainAt        time.Time
+	drained        bool
+	lastUpgradeErr error
+)
+
+// Return this process' status, for health checks and readiness probes.
+func Status() ProcessStatus {
+	s := ProcessStatus{
+		Phase:             StatusServing,
+		PID:               os.Getpid(),
+		Generation:        Generation(),
+		ParentPid:         ParentPid(),
+		StartedAt:         StartedAt(),
+		ActiveConnections: ActiveConnections(),
+	}
+	mu.Lock()
+	if nil != child {
+		s.Phase, s.ChildPID = StatusUpgrading, child.Pid
+	}
+	mu.Unlock()
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	s.LastUpgradeError = lastUpgradeErr
+	since := drainAt
+	if since.IsZero() {
+		since = shutdownAt
+	}
+	if !since.IsZero() {
+		s.Phase, s.DrainingFor = StatusDraining, time.Since(since)
+		if drained {
+			s.Phase = StatusExiting
+		}
+	}
+	return s
+}
+
+// Report whether this process should be sent new connections: whether it's
+// serving, even if it's upgrading, rather than draining or exiting.
+func (s ProcessStatus) Ready() bool {
+	return StatusServing == s.Phase || StatusUpgrading == s.Phase
+}
+
+// Marshal the status with durations in seconds and the error as a string.
+func (s ProcessStatus) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Phase             string    `json:"phase"`
+		PID               int       `json:"pid"`
+		Generation        int       `json:"generation"`
+		ParentPid         int       `json:"ppid"`
+		ChildPID          int       `json:"child_pid,omitempty"`
+		StartedAt         time.Time `json:"started_at"`
+		ActiveConnections int       `json:"active_connections"`
+		DrainingSeconds   float64   `json:"draining_seconds,omitempty"`
+		LastUpgradeError  string    `json:"last_upgrade_error,omitempty"`
+	}{
+		Phase:             s.Phase,
+		PID:               s.PID,
+		Generation:        s.Generation,
+		ParentPid:         s.ParentPid,
+		ChildPID:          s.ChildPID,
+		StartedAt:         s.StartedAt,
+		ActiveConnections: s.ActiveConnections,
+		DrainingSeconds:   s.DrainingFor.Seconds(),
+	}
+	if nil != s.LastUpgradeError {
+		v.LastUpgradeError = s.LastUpgradeError.Error()
+	}
+	return json.Marshal(v)
+}
+
+// Return an http.Handler that reports Status as JSON, with status 200 while
+// this process is Ready and 503 once it's draining, for a load balancer's
+// health check or a Kubernetes readiness probe.
+func StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := Status()
+		code := http.StatusOK
+		if !s.Ready() {
+			code = http.StatusServiceUnavailable
+		}
+		b, err := json.Marshal(s)
+		if nil != err {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		w.Write(append(b, '\n'))
+	})
+}
+
+func statusShutdown() {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	if shutdownAt.IsZero() {
+		shutdownAt = time.Now()
+	}
+}
+
+// Note that a drain began and return a function that notes it finished.
+func statusDrain() func() {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	if drainAt.IsZero() {
+		drainAt = time.Now()
+	}
+	return func() {
+		statusMu.Lock()
+		defer statusMu.Unlock()
+		drained = true
+	}
+}
+
+func statusUpgrade(err error) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	lastUpgradeErr = err
+}
@@ -0,0 +1,157 @@
+package goagain
+
+import (
+	"net"
+	"sort"
+	"time"
+)
+
+// A ConnInfo describes a connection accepted by a TrackingListener and not
+// yet closed.
+type ConnInfo struct {
+
+	// Conn is the connection as Accept returned it; closing it stops it
+	// being counted as active.
+	Conn net.Conn
+
+	// Listener is the TrackingListener that accepted it.
+	Listener *TrackingListener
+
+	// Accepted is when it was accepted and LastActive when it was last
+	// read from or written to.
+	Accepted, LastActive time.Time
+}
+
+// Return how long ago the connection was accepted.
+func (ci ConnInfo) Age() time.Duration {
+	return time.Since(ci.Accepted)
+}
+
+// Return how long ago the connection was last read from or written to.
+func (ci ConnInfo) Idle() time.Duration {
+	return time.Since(ci.LastActive)
+}
+
+// Return every connection accepted by a TrackingListener and still active,
+// oldest first, to see what a draining process is still serving.
+func Connections() []ConnInfo {
+	return trackedInfo(nil)
+}
+
+// Return this listener's active connections, oldest first.
+func (tl *TrackingListener) Connections() []ConnInfo {
+	return trackedInfo(tl)
+}
+
+// A StreamPolicy decides which of a draining process' long-lived connections,
+// such as WebSockets and server-sent event streams, to close and which to
+// keep serving.  The zero StreamPolicy keeps every connection until its
+// client closes it, however long that takes.
+type StreamPolicy struct {
+
+	// MaxIdle, if not zero, closes connections neither read from nor
+	// written to for this long.
+	MaxIdle time.Duration
+
+	// MaxAge, if not zero, closes connections accepted this long ago.
+	MaxAge time.Duration
+
+	// MaxCount, if not zero, is the most connections kept; the idlest are
+	// closed first.
+	MaxCount int
+
+	// Interval is how often DrainStreams applies the policy, every second
+	// by default.
+	Interval time.Duration
+
+	// Evict, if not nil, is called with every connection the policy is
+	// about to close, in place of OnForceClose, to tell the client to
+	// reconnect (which takes it to the new generation) or to migrate the
+	// connection elsewhere.  It needn't close the connection.
+	Evict func(ci ConnInfo)
+}
+
+// Close the connections accepted by the given TrackingListener or, if it's
+// nil, by any, that the policy says to and return how many there were.
+func (p StreamPolicy) Apply(tl *TrackingListener) int {
+	cis := trackedInfo(tl)
+	var evict []ConnInfo
+	keep := cis[:0]
+	for _, ci := range cis {
+		if 0 != p.MaxIdle && ci.Idle() >= p.MaxIdle || 0 != p.MaxAge && ci.Age() >= p.MaxAge {
+			evict = append(evict, ci)
+		} else {
+			keep = append(keep, ci)
+		}
+	}
+	if 0 != p.MaxCount && len(keep) > p.MaxCount {
+		sort.SliceStable(keep, func(i, j int) bool {
+			return keep[i].LastActive.Before(keep[j].LastActive)
+		})
+		evict = append(evict, keep[:len(keep)-p.MaxCount]...)
+	}
+	for _, ci := range evict {
+		if nil != p.Evict {
+			p.Evict(ci)
+		} else if nil != conf().OnForceClose {
+			conf().OnForceClose(ci.Conn.(*trackedConn).Conn)
+		}
+		ci.Conn.Close()
+	}
+	if 0 < len(evict) {
+		event(
+			"evict",
+			[]interface{}{"connections", len(evict), "remaining", len(cis) - len(evict)},
+			"closed", len(evict), "connections by policy;", len(cis)-len(evict), "remain",
+		)
+	}
+	return len(evict)
+}
+
+// Stop accepting connections by closing the TrackingListener and keep
+// serving those already accepted, applying the policy to them every
+// Interval, until the last is closed.  This suits long-lived streaming
+// connections, which may take hours to end on their own, better than Drain's
+// single timeout: the new generation takes every new connection meanwhile.
+func DrainStreams(tl *TrackingListener, p StreamPolicy) error {
+	logln("draining streams from", tl.Addr())
+	defer drainStarted()()
+	if err := closeAcceptor(tl); nil != err {
+		return err
+	}
+	interval := p.Interval
+	if 0 == interval {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	idle := tl.c.idleChan()
+	for {
+		p.Apply(tl)
+		select {
+		case <-idle:
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func trackedInfo(tl *TrackingListener) []ConnInfo {
+	trackedMu.Lock()
+	var cis []ConnInfo
+	for c := range tracked {
+		if nil == tl || c.tl == tl {
+			cis = append(cis, ConnInfo{
+				Conn:       c,
+				Listener:   c.tl,
+				Accepted:   c.accepted,
+				LastActive: time.Unix(0, c.active.Load()),
+			})
+		}
+	}
+	trackedMu.Unlock()
+	sort.Slice(cis, func(i, j int) bool {
+		return cis[i].Accepted.Before(cis[j].Accepted)
+	})
+	return cis
+}
@@ -0,0 +1,241 @@
+//go:build !windows
+
+package goagain
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Whether a child can inherit file descriptors.
+const canInherit = true
+
+const (
+	sigUSR1 = syscall.SIGUSR1
+	sigUSR2 = syscall.SIGUSR2
+	sigTTIN = syscall.SIGTTIN
+	sigTTOU = syscall.SIGTTOU
+)
+
+func notifySignals(ch chan<- os.Signal, sigs ...os.Signal) {
+	signal.Notify(ch, sigs...)
+}
+
+func stopSignals(ch chan<- os.Signal) {
+	signal.Stop(ch)
+}
+
+func kill(pid int, sig syscall.Signal) error {
+	return syscall.Kill(pid, sig)
+}
+
+// Report whether no process can catch the given signal.
+func uncatchable(sig syscall.Signal) bool {
+	return syscall.SIGKILL == sig || syscall.SIGSTOP == sig
+}
+
+// Reap a process that exits as our child, as the parent does under the
+// Double strategy, so it doesn't linger as a zombie.
+func reap(pid int) {
+	syscall.Wait4(pid, nil, 0, nil)
+}
+
+// Fork and exec a process with the given arguments, environment, and
+// standard streams and the given file descriptors at the same numbers they
+// have here.
+func spawn(argv0 string, args, env []string, dir string, std [3]*os.File, fds []int) (int, error) {
+	return syscall.ForkExec(argv0, args, &syscall.ProcAttr{
+		Dir:   dir,
+		Env:   env,
+		Files: childFiles(std, fds),
+		Sys:   sysProcAttr(),
+	})
+}
+
+// Return the files for a child process: the standard streams followed by
+// the given file descriptors.  Hand the child raw file descriptors rather
+// than *os.Files since (*os.File).Fd, which os.StartProcess calls, would put
+// the listeners shared with this process into blocking mode while it's still
+// accepting connections.  Each is at the same number in the child as the
+// duplicate here so the environment describes both.
+func childFiles(std [3]*os.File, fds []int) []uintptr {
+	maxfd := syscall.Stderr
+	for _, fd := range fds {
+		if fd > maxfd {
+			maxfd = fd
+		}
+	}
+	files := make([]uintptr, maxfd+1)
+	for i := range files {
+		files[i] = ^uintptr(0)
+	}
+	for i, f := range std {
+		files[i] = f.Fd()
+	}
+	for _, fd := range fds {
+		files[fd] = uintptr(fd)
+	}
+	return files
+}
+
+func closeFDs(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+}
+
+func fileListener(fd uintptr, name string) (l net.Listener, err error) {
+	if err = checkListenerFD(int(fd)); nil != err {
+		syscall.Close(int(fd))
+		return nil, &InheritError{FD: fd, Name: name, Err: err}
+	}
+
+	// The file descriptor arrives in blocking mode if it came by way of
+	// File, which switches it, and in non-blocking mode if it came from
+	// systemd or straight from a net.Listener.  The runtime poller needs it
+	// non-blocking so make it so regardless, and before wrapping it in an
+	// *os.File: Fd, which net.FileListener calls, then leaves it as it is
+	// rather than switch the socket, which the parent may still be
+	// accepting from, into blocking mode.
+	if err = syscall.SetNonblock(int(fd), true); nil != err {
+		syscall.Close(int(fd))
+		return
+	}
+
+	// Close the original by way of its *os.File, which takes it out of the
+	// runtime poller (kqueue on macOS and BSD) before closing it, rather
+	// than behind the poller's back, and leaves no finalizer to close the
+	// same number again once it's been reused.
+	f := os.NewFile(fd, name)
+	defer func() {
+		if cerr := f.Close(); nil == err {
+			err = cerr
+		}
+	}()
+	l, err = net.FileListener(f)
+	if nil != err {
+		return
+	}
+
+	// Names given by fileName say which address the listener's bound to.
+	if actual := fileName(l); strings.HasSuffix(name, "->") && actual != name {
+		l.Close()
+		return nil, &InheritError{
+			FD:   fd,
+			Name: name,
+			Err:  fmt.Errorf("bound to %s instead", actual),
+		}
+	}
+	if 0 <= conf().Linger {
+		if err = SetLinger(l, conf().Linger); nil != err {
+			return
+		}
+	}
+	resetSocketOptions(l)
+
+	// Remove an inherited socket file when the listener's closed, as if
+	// this process had bound it, unless it's handed on in turn.
+	if ul, ok := l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(true)
+	}
+	return
+}
+
+// Duplicate a file descriptor for the new process so the original can be
+// closed or collected without taking the new process' copy with it.  The
+// duplicate is close-on-exec from the start, on every platform that has
+// F_DUPFD_CLOEXEC, so a process forked meanwhile by another goroutine
+// doesn't inherit it by accident.
+func dup(fd int) (int, error) {
+	nfd, _, errno := syscall.Syscall(
+		syscall.SYS_FCNTL,
+		uintptr(fd),
+		syscall.F_DUPFD_CLOEXEC,
+		0,
+	)
+	if 0 != errno {
+		return -1, errno
+	}
+	return int(nfd), nil
+}
+
+func noCloseOnExec(fd int) error {
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_FCNTL,
+		uintptr(fd),
+		syscall.F_SETFD,
+		0,
+	)
+	if 0 != errno {
+		return errno
+	}
+	return nil
+}
+
+// Check that an inherited file descriptor is a listening stream socket
+// without a pending error.
+func checkListenerFD(fd int) error {
+	typ, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	switch err {
+	case nil:
+	case syscall.EBADF:
+		return errors.New("not open")
+	case syscall.ENOTSOCK:
+		return errors.New("not a socket")
+	default:
+		return err
+	}
+	if syscall.SOCK_STREAM != typ {
+		return fmt.Errorf("socket type %d isn't SOCK_STREAM", typ)
+	}
+	accepting, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ACCEPTCONN)
+	if nil != err {
+		return err
+	}
+	if 0 == accepting {
+		return errors.New("not listening")
+	}
+	errno, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if nil != err {
+		return err
+	}
+	if 0 != errno {
+		return fmt.Errorf("pending error: %v", syscall.Errno(errno))
+	}
+	return nil
+}
+
+// Return SysProcAttr or, by default, empty attributes.
+func sysProcAttr() *syscall.SysProcAttr {
+	if nil != conf().SysProcAttr {
+		return conf().SysProcAttr
+	}
+	return &syscall.SysProcAttr{}
+}
+
+func closeOnExec(fd uintptr) {
+	syscall.CloseOnExec(int(fd))
+}
+
+func filePacketConn(fd uintptr, name string) (net.PacketConn, error) {
+	if err := syscall.SetNonblock(int(fd), true); nil != err {
+		syscall.Close(int(fd))
+		return nil, err
+	}
+	f := os.NewFile(fd, name)
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+func setsockoptInt(fd uintptr, level, name, value int) error {
+	return syscall.SetsockoptInt(int(fd), level, name, value)
+}
+
+func setsockoptLinger(fd uintptr, linger *syscall.Linger) error {
+	return syscall.SetsockoptLinger(int(fd), syscall.SOL_SOCKET, syscall.SO_LINGER, linger)
+}
@@ -0,0 +1,80 @@
+//go:build !windows
+
+package goagain
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Return a duplicate of a listener's file descriptor, as a child inherits it.
+func testListenerFD(t *testing.T, l net.Listener) uintptr {
+	t.Helper()
+	fd, err := listenerFD(l)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return uintptr(fd)
+}
+
+func TestFileListener(t *testing.T) {
+	l := testListener(t)
+	il, err := fileListener(testListenerFD(t, l), fileName(l))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer il.Close()
+	if l.Addr().String() != il.Addr().String() {
+		t.Errorf("inherited %v, not %v", il.Addr(), l.Addr())
+	}
+	testAccept(t, il)
+}
+
+func TestFileListenerRejects(t *testing.T) {
+	r, w, err := os.Pipe()
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	pfd, err := syscall.Dup(int(w.Fd()))
+	if nil != err {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// A connected socket that's lost its peer, as a parent that crashed
+	// partway through shutting down might leave behind.
+	l := testListener(t)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	sc, err := l.Accept()
+	if nil != err {
+		t.Fatal(err)
+	}
+	sc.Close()
+	cfd, err := dupConn(c.(*net.TCPConn))
+	if nil != err {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	for name, fd := range map[string]uintptr{
+		"pipe":      uintptr(pfd),
+		"connected": uintptr(cfd),
+	} {
+		il, err := fileListener(fd, name)
+		var ie *InheritError
+		if !errors.As(err, &ie) {
+			t.Errorf("%s: got %v, %v, not an InheritError", name, il, err)
+			continue
+		}
+		if fd != ie.FD || name != ie.Name {
+			t.Errorf("%s: InheritError names %d %q", name, ie.FD, ie.Name)
+		}
+	}
+}
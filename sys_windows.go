@@ -0,0 +1,222 @@
+package goagain
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Windows has none of these signals.  These stand in for them so they can be
+// delivered, like the signals Windows does have, by Signal.
+const (
+	sigUSR1 = syscall.Signal(0xa)
+	sigUSR2 = syscall.Signal(0xc)
+	sigTTIN = syscall.Signal(0x15)
+	sigTTOU = syscall.Signal(0x16)
+)
+
+// Whether a child can inherit file descriptors.
+const canInherit = false
+
+// errNoInherit is returned by everything that would hand a file descriptor
+// to another process, which Windows doesn't support.
+var errNoInherit = errors.New("goagain: can't inherit file descriptors on Windows; use the ReusePort strategy")
+
+const (
+	eventModifyState = 0x0002
+	stillActive      = 259
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procCreateEventW = kernel32.NewProc("CreateEventW")
+	procOpenEventW   = kernel32.NewProc("OpenEventW")
+	procSetEvent     = kernel32.NewProc("SetEvent")
+)
+
+// Channels to relay each signal to when its named event is set, guarded by
+// eventsMu.
+var (
+	eventsMu   sync.Mutex
+	eventChans = make(map[syscall.Signal][]chan<- os.Signal)
+)
+
+// Relay the given signals to ch as signal.Notify does, both those Windows
+// delivers, like SIGINT for Ctrl-C, and those delivered by Signal, which
+// sets a named event for each signal this process awaits.
+func notifySignals(ch chan<- os.Signal, sigs ...os.Signal) {
+	signal.Notify(ch, sigs...)
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	for _, sig := range sigs {
+		s, ok := sig.(syscall.Signal)
+		if !ok {
+			continue
+		}
+		if _, ok := eventChans[s]; !ok {
+			if err := watchEvent(s); nil != err {
+				logln("creating event for", s, err)
+				continue
+			}
+		}
+		eventChans[s] = append(eventChans[s], ch)
+	}
+}
+
+func stopSignals(ch chan<- os.Signal) {
+	signal.Stop(ch)
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	for s, chs := range eventChans {
+		for i, c := range chs {
+			if c == ch {
+				eventChans[s] = append(chs[:i], chs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Create the named event for a signal to this process and relay the signal
+// every time it's set, without blocking, as signal.Notify does.  Call with
+// eventsMu held.
+func watchEvent(sig syscall.Signal) error {
+	name, err := syscall.UTF16PtrFromString(eventName(os.Getpid(), sig))
+	if nil != err {
+		return err
+	}
+	h, _, err := procCreateEventW.Call(0, 0, 0, uintptr(unsafe.Pointer(name)))
+	if 0 == h {
+		return err
+	}
+	eventChans[sig] = nil
+	go func() {
+		for {
+			if _, err := syscall.WaitForSingleObject(syscall.Handle(h), syscall.INFINITE); nil != err {
+				logln("waiting for event for", sig, err)
+				return
+			}
+			eventsMu.Lock()
+			for _, ch := range eventChans[sig] {
+				select {
+				case ch <- sig:
+				default:
+				}
+			}
+			eventsMu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func eventName(pid int, sig syscall.Signal) string {
+	return fmt.Sprintf(`Local\goagain-%d-%d`, pid, int(sig))
+}
+
+// Send a signal by setting the named event the process created for it or,
+// given SIGKILL, terminate the process.  Signal zero tests whether the
+// process is running.
+func kill(pid int, sig syscall.Signal) error {
+	switch sig {
+	case 0:
+		h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+		if nil != err {
+			return syscall.ESRCH
+		}
+		defer syscall.CloseHandle(h)
+		var code uint32
+		if err := syscall.GetExitCodeProcess(h, &code); nil != err {
+			return err
+		}
+		if stillActive != code {
+			return syscall.ESRCH
+		}
+		return nil
+	case syscall.SIGKILL:
+		p, err := os.FindProcess(pid)
+		if nil != err {
+			return syscall.ESRCH
+		}
+		defer p.Release()
+		return p.Kill()
+	}
+	name, err := syscall.UTF16PtrFromString(eventName(pid, sig))
+	if nil != err {
+		return err
+	}
+	h, _, err := procOpenEventW.Call(eventModifyState, 0, uintptr(unsafe.Pointer(name)))
+	if 0 == h {
+		return fmt.Errorf("process %d doesn't await %v: %w", pid, sig, err)
+	}
+	defer syscall.CloseHandle(syscall.Handle(h))
+	if r, _, err := procSetEvent.Call(h); 0 == r {
+		return err
+	}
+	return nil
+}
+
+// Report whether no process can catch the given signal.
+func uncatchable(sig syscall.Signal) bool {
+	return syscall.SIGKILL == sig
+}
+
+// Nothing to do since Windows processes don't linger as zombies.
+func reap(pid int) {}
+
+// Start a process with the given arguments, environment, and standard
+// streams, which can't inherit other file descriptors.
+func spawn(argv0 string, args, env []string, dir string, std [3]*os.File, fds []int) (int, error) {
+	if 0 != len(fds) {
+		closeFDs(fds)
+		return 0, errNoInherit
+	}
+	p, err := os.StartProcess(argv0, args, &os.ProcAttr{
+		Dir:   dir,
+		Env:   env,
+		Files: std[:],
+		Sys:   SysProcAttr,
+	})
+	if nil != err {
+		return 0, err
+	}
+	pid := p.Pid
+	p.Release()
+	return pid, nil
+}
+
+func closeFDs(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(syscall.Handle(fd))
+	}
+}
+
+func fileListener(fd uintptr, name string) (net.Listener, error) {
+	return nil, errNoInherit
+}
+
+func filePacketConn(fd uintptr, name string) (net.PacketConn, error) {
+	return nil, errNoInherit
+}
+
+func dup(fd int) (int, error) {
+	return -1, errNoInherit
+}
+
+func noCloseOnExec(fd int) error {
+	return nil
+}
+
+func closeOnExec(fd uintptr) {}
+
+func setsockoptInt(fd uintptr, level, name, value int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), level, name, value)
+}
+
+func setsockoptLinger(fd uintptr, linger *syscall.Linger) error {
+	return syscall.SetsockoptLinger(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_LINGER, linger)
+}
@@ -0,0 +1,90 @@
+//go:build !windows
+
+package goagain
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// The first file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+// Reconstruct the sockets passed by systemd socket activation, per
+// sd_listen_fds(3), if this process was activated: stream sockets as
+// listeners and datagram sockets as packet connections.  The LISTEN_*
+// environment variables are unset so no child mistakes them for its own; the
+// sockets reach the child the usual way, through GOAGAIN_* variables.
+func systemdSockets() (ls []net.Listener, cs []net.PacketConn, err error) {
+	var pid, n int
+	if _, err := fmt.Sscan(getenv("LISTEN_PID"), &pid); nil != err {
+		return nil, nil, nil
+	}
+	if syscall.Getpid() != pid {
+		return nil, nil, nil
+	}
+	if _, err = fmt.Sscan(getenv("LISTEN_FDS"), &n); nil != err {
+		return nil, nil, fmt.Errorf("malformed LISTEN_FDS: %v", err)
+	}
+	names := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	mu.Lock()
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	mu.Unlock()
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		name := fmt.Sprintf("systemd/%d", i)
+		if i < len(names) && "" != names[i] {
+			name = names[i]
+		}
+		syscall.CloseOnExec(fd)
+		var typ int
+		if typ, err = syscall.GetsockoptInt(
+			fd,
+			syscall.SOL_SOCKET,
+			syscall.SO_TYPE,
+		); nil != err {
+			return
+		}
+		if syscall.SOCK_DGRAM == typ {
+			var c net.PacketConn
+			if c, err = filePacketConn(uintptr(fd), name); nil != err {
+				return
+			}
+			cs = append(cs, c)
+			continue
+		}
+		var l net.Listener
+		if l, err = fileListener(uintptr(fd), name); nil != err {
+			return
+		}
+
+		// systemd owns the socket file, not this process.
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		ls = append(ls, l)
+	}
+	return
+}
+
+// Send the given state, such as "READY=1" or "STATUS=draining", to systemd
+// per sd_notify(3), if this process runs as a Type=notify service, which
+// sets NOTIFY_SOCKET.  Otherwise do nothing.
+func SDNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if "" == name {
+		return nil
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if nil != err {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,13 @@
+package goagain
+
+import "net"
+
+// There's no systemd on Windows.
+func systemdSockets() ([]net.Listener, []net.PacketConn, error) {
+	return nil, nil, nil
+}
+
+// There's no systemd on Windows.
+func SDNotify(state string) error {
+	return nil
+}
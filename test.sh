@@ -33,6 +33,29 @@ do
     cd "$OLDPWD"
 done
 
+# Connections made while a child inherits the listener and takes over must all
+# be accepted, by one process or the other.
+cd "example/single"
+go build
+./single &
+PID="$!"
+sleep 1
+for I in $(seq 200)
+do
+    nc "127.0.0.1" "48879"
+done >"load.out" &
+LOAD="$!"
+kill -USR2 "$PID"
+sleep 2
+kill -USR2 "$(findproc "single")"
+wait "$LOAD"
+[ "$(grep -c "Hello, world!" "load.out")" = 200 ]
+rm "load.out"
+kill -TERM "$(findproc "single")"
+sleep 2
+[ -z "$(findproc "single")" ]
+cd "$OLDPWD"
+
 cd "example/double"
 go build
 ./double &
@@ -52,3 +75,28 @@ sleep 3
 [ ! -d "/proc/$PID" ]
 [ -z "$(findproc "double")" ]
 cd "$OLDPWD"
+
+# Restarting under load must be free of data races.
+cd "example/single"
+go build -race
+./single 2>"race.out" &
+PID="$!"
+sleep 1
+for I in $(seq 100)
+do
+    nc "127.0.0.1" "48879" || true
+done >"/dev/null" &
+LOAD="$!"
+for _ in _ _
+do
+    kill -USR2 "$PID"
+    sleep 3
+    PID="$(findproc "single")"
+done
+wait "$LOAD"
+kill -TERM "$PID"
+sleep 3
+[ -z "$(findproc "single")" ]
+! grep "DATA RACE" "race.out"
+rm "race.out"
+cd "$OLDPWD"
@@ -0,0 +1,85 @@
+package goagain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrThrottled is returned by ForkExec when a restart is attempted too soon
+// after the last or after too many consecutive failures.
+var ErrThrottled = errors.New("goagain: restart throttled")
+
+// These are read without synchronization so set them before calling Wait.
+var (
+	// MinRestartInterval, if not zero, is the least time allowed between
+	// one restart attempt and the next.  After a failed restart, the
+	// interval (or a second, if it's zero but MaxRestartFailures isn't)
+	// doubles with each consecutive failure.
+	MinRestartInterval time.Duration
+
+	// MaxRestartFailures, if not zero, is the number of consecutive failed
+	// restarts after which no more are attempted until
+	// ResetRestartFailures is called.  A restart fails if the child can't
+	// be spawned or is killed or exits before taking over.
+	MaxRestartFailures int
+
+	// OnThrottle, if not nil, is called with the number of consecutive
+	// failures the first time a restart is refused for reaching
+	// MaxRestartFailures.
+	OnThrottle func(failures int)
+)
+
+// The time of the last restart attempt, the number of consecutive failures
+// since, and whether OnThrottle has been called for them, guarded by mu.
+var (
+	lastAttempt time.Time
+	failures    int
+	throttled   bool
+)
+
+// Forget past failed restarts so restarts may be attempted again.
+func ResetRestartFailures() {
+	mu.Lock()
+	defer mu.Unlock()
+	failures, throttled = 0, false
+}
+
+// Note that a restart failed and put PIDFile back.  Call with mu held.
+func restartFailed() {
+	failures++
+	restorePIDFile()
+	countMetric(func(m *Metrics) { m.UpgradesFailed++ })
+}
+
+// Return ErrThrottled if a restart may not be attempted now and otherwise
+// note that one is being attempted.
+func throttle() error {
+	if 0 == conf().MinRestartInterval && 0 == conf().MaxRestartFailures {
+		return nil
+	}
+	mu.Lock()
+	if 0 != conf().MaxRestartFailures && failures >= conf().MaxRestartFailures {
+		notify := !throttled
+		throttled = true
+		n := failures
+		mu.Unlock()
+		if notify && nil != conf().OnThrottle {
+			conf().OnThrottle(n)
+		}
+		return fmt.Errorf("%w after %d consecutive failures", ErrThrottled, n)
+	}
+	defer mu.Unlock()
+	interval := conf().MinRestartInterval
+	if 0 < failures {
+		if 0 == interval {
+			interval = time.Second
+		}
+		interval <<= uint(failures)
+	}
+	if wait := interval - time.Since(lastAttempt); 0 < wait {
+		return fmt.Errorf("%w for another %v", ErrThrottled, wait)
+	}
+	lastAttempt = time.Now()
+	return nil
+}
@@ -0,0 +1,34 @@
+package goagain
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// ProcTitle, if not nil, is called with this process' role in a restart
+// whenever it changes, such as "gen 3", "old, draining", or "master, gen 1",
+// and returns the title ps and top should show for it, so an operator can
+// tell the old process from the new.  Set it to DefaultProcTitle for titles
+// like "myapp (gen 3)".  Set it before calling Wait.
+var ProcTitle func(role string) string
+
+// Return the program's name followed by its role in parentheses.
+func DefaultProcTitle(role string) string {
+	return fmt.Sprintf("%s (%s)", filepath.Base(argv()[0]), role)
+}
+
+// Set this process' title to what ProcTitle makes of the given role, if
+// ProcTitle is set.  Don't call with mu held.
+func setRole(role string) {
+	if nil == conf().ProcTitle {
+		return
+	}
+	if err := SetProcTitle(conf().ProcTitle(role)); nil != err {
+		logln("setting process title", err)
+	}
+}
+
+// Return this process' role while it serves.
+func servingRole() string {
+	return fmt.Sprintf("gen %d", Generation())
+}
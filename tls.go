@@ -0,0 +1,79 @@
+package goagain
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+// A Certificate is a TLS certificate and key pair that can be reloaded from
+// disk in place.  Set a tls.Config's GetCertificate to the Certificate's and
+// new connections will use whichever certificate was loaded most recently
+// while existing connections carry on undisturbed.  Reloading on SIGHUP this
+// way handles the common certificate rotation without a restart.
+type Certificate struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// Load a certificate and key pair from the given PEM-encoded files.
+func LoadCertificate(certFile, keyFile string) (*Certificate, error) {
+	c := &Certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); nil != err {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Return the most recently loaded certificate; suitable for use as
+// tls.Config's GetCertificate.
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Reload the certificate and key pair from disk; suitable for use as
+// OnSIGHUP.  On error, the previously loaded certificate remains in use.
+func (c *Certificate) OnSIGHUP(l net.Listener) error {
+	return c.Reload()
+}
+
+// Reload the certificate and key pair from disk on every SIGHUP, after
+// OnSIGHUP and any other reloads, by way of HandleSIGHUP.
+func (c *Certificate) ReloadOnSIGHUP() {
+	HandleSIGHUP(c.Reload)
+}
+
+// Reload the certificate and key pair from disk.  On error, the previously
+// loaded certificate remains in use.
+func (c *Certificate) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if nil != err {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+// Wrap a listener, inherited or not, as tls.NewListener does but so that
+// Exec and ForkExec can still find the underlying socket to hand it to the
+// new process, which wraps it again.  Set the tls.Config's GetCertificate to
+// a Certificate's to rotate certificates without a restart, too.
+func NewTLSListener(l net.Listener, config *tls.Config) net.Listener {
+	return &tlsListener{Listener: tls.NewListener(l, config), inner: l}
+}
+
+type tlsListener struct {
+	net.Listener
+	inner net.Listener
+}
+
+// Return the wrapped listener.
+func (tl *tlsListener) Unwrap() net.Listener {
+	return tl.inner
+}
@@ -0,0 +1,30 @@
+package goagain
+
+import "net"
+
+// Keep the socket files of the given Unix domain listeners and every
+// Manager's when they're closed.  A listener bound by this process removes
+// its socket file when it's closed, which would pull the socket out from
+// under a child that inherited it and is now taking over.
+func keepSocketFiles(ls []net.Listener) {
+	mu.Lock()
+	for _, m := range managers {
+		ls = append(ls, m.listeners...)
+	}
+	mu.Unlock()
+	for _, l := range ls {
+		for nil != l {
+			if ul, ok := l.(*net.UnixListener); ok {
+				ul.SetUnlinkOnClose(false)
+				break
+			}
+			u, ok := l.(interface {
+				Unwrap() net.Listener
+			})
+			if !ok {
+				break
+			}
+			l = u.Unwrap()
+		}
+	}
+}
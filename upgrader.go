@@ -0,0 +1,159 @@
+package goagain
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// An Upgrader is the single entry point to the restart protocol for a
+// process that may or may not be a child: it inherits or binds listeners,
+// tells the parent process, if any, to let go once this one is ready, and
+// handles signals in the background, so the caller needn't get the order of
+// Listener, Kill, and Wait right.
+//
+//	upg, err := goagain.New(goagain.Options{})
+//	l, err := upg.Listen("tcp", addr)
+//	go serve(l)
+//	err = upg.Ready()
+//	<-upg.Exit()
+//
+// There may be only one Upgrader per process and it doesn't support the
+// Double strategy.
+type Upgrader struct {
+	opts       Options
+	ls         []net.Listener
+	ready      bool
+	stop       context.CancelFunc
+	exit       chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+	sig        syscall.Signal
+	err        error
+	states     chan io.WriteCloser
+	stateTaken bool
+}
+
+// The Upgrader, if one has been created, guarded by mu.
+var upgrader *Upgrader
+
+// Create the process' Upgrader, reconstructing the listeners inherited from
+// the parent process, if any, for Listen to return.  The options are checked,
+// returning an error matching ErrInvalidOptions if they don't make sense,
+// before any take effect.
+func New(opts Options) (*Upgrader, error) {
+	if err := opts.validate(); nil != err {
+		return nil, err
+	}
+	mu.Lock()
+	exists := nil != upgrader
+	mu.Unlock()
+	if exists {
+		return nil, errors.New("goagain: only one Upgrader per process")
+	}
+	opts.apply()
+	if err := loadInherited(); nil != err {
+		return nil, err
+	}
+	u := &Upgrader{
+		opts:   opts,
+		exit:   make(chan struct{}),
+		states: make(chan io.WriteCloser, 1),
+	}
+	u.ctx, u.cancel = context.WithCancel(context.Background())
+	mu.Lock()
+	defer mu.Unlock()
+	if nil != upgrader {
+		u.cancel()
+		return nil, errors.New("goagain: only one Upgrader per process")
+	}
+	upgrader = u
+	return u, nil
+}
+
+// Create the process' Upgrader as New does.
+func NewUpgrader(opts Options) (*Upgrader, error) {
+	return New(opts)
+}
+
+// Return the listener inherited from the parent process on the given network
+// and address or, if there isn't one, a fresh one.  Every listener returned
+// is handed to the child process on restart.  Call Listen only before Ready.
+func (u *Upgrader) Listen(network, addr string) (net.Listener, error) {
+	mu.Lock()
+	ready := u.ready
+	mu.Unlock()
+	if ready {
+		return nil, errors.New("goagain: Listen called after Ready")
+	}
+	l, err := Listen(network, addr)
+	if nil != err {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	u.ls = append(u.ls, l)
+	return l, nil
+}
+
+// Say this process is ready to serve: close the inherited listeners nobody
+// asked for, tell the parent process, if any, to let go, and start handling
+// signals in the background.
+func (u *Upgrader) Ready() error {
+	mu.Lock()
+	if u.ready {
+		mu.Unlock()
+		return errors.New("goagain: Ready called twice")
+	}
+	u.ready = true
+	ls := append([]net.Listener(nil), u.ls...)
+	mu.Unlock()
+	closeInherited()
+	if "" != getenv(envKey("PPID")) {
+		if err := Kill(); nil != err {
+			return err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	u.stop = cancel
+	go func() {
+		u.sig, u.err = WaitContext(ctx, ls...)
+		close(u.exit)
+		u.cancel()
+	}()
+	return nil
+}
+
+// Restart as Restart does.
+func (u *Upgrader) Upgrade() error {
+	return Restart()
+}
+
+// Return a channel that's closed once this process should stop serving and
+// exit gracefully, because a child took over or a signal said so.
+func (u *Upgrader) Exit() <-chan struct{} {
+	return u.exit
+}
+
+// Return a context that's canceled when the channel returned by Exit is
+// closed, to pass to database queries, background workers, and outbound
+// requests so they stop once this process is shutting down.
+func (u *Upgrader) Context() context.Context {
+	return u.ctx
+}
+
+// Return the signal and error that ended signal handling, once the channel
+// returned by Exit is closed.
+func (u *Upgrader) Err() (syscall.Signal, error) {
+	<-u.exit
+	return u.sig, u.err
+}
+
+// Stop handling signals, which closes the channel returned by Exit.
+func (u *Upgrader) Stop() {
+	if nil != u.stop {
+		u.stop()
+	}
+}
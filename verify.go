@@ -0,0 +1,20 @@
+package goagain
+
+import (
+	"net"
+	"time"
+)
+
+// Dial the listener's own address to verify it's still being served, as it
+// should be once the handoff is complete, catching an accept loop that died
+// right after the parent was killed.  Failures are logged as well as
+// returned.
+func Verify(l net.Listener, timeout time.Duration) error {
+	addr := l.Addr()
+	c, err := net.DialTimeout(addr.Network(), addr.String(), timeout)
+	if nil != err {
+		logln("not serving", addr, err)
+		return err
+	}
+	return c.Close()
+}
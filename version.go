@@ -0,0 +1,168 @@
+package goagain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+// ErrIncompatible is wrapped in the *VersionError reported when a child's
+// build is refused.
+var ErrIncompatible = errors.New("goagain: incompatible child")
+
+// These are read without synchronization so set them before calling Wait.
+var (
+	// Version identifies this build, as a release or commit, set with
+	// -ldflags "-X github.com/rcrowley/goagain.Version=v1.2.3", say.  It
+	// defaults to the VCS revision stamped in the binary, if any.
+	Version string
+
+	// ProtocolVersion, if not zero, is the version of whatever this
+	// program's generations share, such as the format of the state they
+	// stream to one another.  A child reports it to its parent, which by
+	// default refuses to hand off to one with a lower ProtocolVersion,
+	// such as an old binary left on disk, or one that reports none.
+	ProtocolVersion int
+
+	// Compatible, if not nil, decides in place of ProtocolVersion whether
+	// the parent hands off to a child; an error kills the child instead.
+	Compatible func(parent, child BuildInfo) error
+)
+
+// A BuildInfo is what a child reports about itself to its parent before
+// taking over when either sets ProtocolVersion or Compatible.
+type BuildInfo struct {
+	PID             int    `json:"pid"`
+	Version         string `json:"version"`
+	ProtocolVersion int    `json:"protocol_version"`
+}
+
+// A VersionError reports a child refused for its build.
+type VersionError struct {
+	Parent, Child BuildInfo
+	Err           error
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf(
+		"goagain: refusing child %d (version %q, protocol %d) from version %q, protocol %d: %v",
+		e.Child.PID,
+		e.Child.Version,
+		e.Child.ProtocolVersion,
+		e.Parent.Version,
+		e.Parent.ProtocolVersion,
+		e.Err,
+	)
+}
+
+func (e *VersionError) Unwrap() error {
+	return e.Err
+}
+
+// The read end of the pipe over which the child in flight reports its
+// build, guarded by mu.
+var versionPipe *os.File
+
+// Return this process' BuildInfo.
+func buildInfo() BuildInfo {
+	v := conf().Version
+	if "" == v {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range bi.Settings {
+				if "vcs.revision" == s.Key {
+					v = s.Value
+				}
+			}
+		}
+	}
+	return BuildInfo{PID: syscall.Getpid(), Version: v, ProtocolVersion: conf().ProtocolVersion}
+}
+
+// Open a pipe for the child about to be spawned to report its build over, if
+// this process checks it, and return the write end, which is the caller's to
+// close once the child's spawned.  Call with mu held.
+func openVersionPipe() (*os.File, error) {
+	if nil != versionPipe {
+		versionPipe.Close()
+		versionPipe = nil
+	}
+	if !canInherit || 0 == conf().ProtocolVersion && nil == conf().Compatible {
+		return nil, nil
+	}
+	r, w, err := os.Pipe()
+	if nil != err {
+		return nil, err
+	}
+	versionPipe = r
+	extraFiles["version"] = w
+	return w, nil
+}
+
+// Close the write end of the pipe now that the child's been spawned, or the
+// whole pipe if it hasn't.  Call with mu held.
+func closeVersionPipe(w *os.File, spawned bool) {
+	if nil == w {
+		return
+	}
+	delete(extraFiles, "version")
+	w.Close()
+	if !spawned && nil != versionPipe {
+		versionPipe.Close()
+		versionPipe = nil
+	}
+}
+
+// Write this process' BuildInfo to the pipe inherited for the purpose, if
+// there is one.
+func writeVersion() error {
+	manifest, err := Manifest()
+	if nil != err {
+		return err
+	}
+	fd, ok := manifest["version"]
+	if !ok {
+		return nil
+	}
+	f := os.NewFile(fd, "version")
+	defer f.Close()
+	return json.NewEncoder(f).Encode(buildInfo())
+}
+
+// Read the BuildInfo the child in flight reported, before it said it was
+// ready, and decide whether to hand off to it.  A child that reported
+// nothing, because it doesn't know to, reports only its PID.
+func checkVersion(pid int) error {
+	mu.Lock()
+	r := versionPipe
+	versionPipe = nil
+	mu.Unlock()
+	if nil == r {
+		return nil
+	}
+	defer r.Close()
+	child := BuildInfo{PID: pid}
+	r.SetReadDeadline(time.Now().Add(time.Second))
+	if err := json.NewDecoder(r).Decode(&child); nil != err {
+		logln("child", pid, "reported no version:", err)
+	}
+	parent := buildInfo()
+	event(
+		"version",
+		[]interface{}{"child", pid, "version", child.Version, "protocol", child.ProtocolVersion},
+		"child", pid, "is version", child.Version, "protocol", child.ProtocolVersion,
+	)
+	var err error
+	if nil != conf().Compatible {
+		err = conf().Compatible(parent, child)
+	} else if child.ProtocolVersion < parent.ProtocolVersion {
+		err = fmt.Errorf("protocol %d is older than %d", child.ProtocolVersion, parent.ProtocolVersion)
+	}
+	if nil != err {
+		return &VersionError{Parent: parent, Child: child, Err: fmt.Errorf("%w: %v", ErrIncompatible, err)}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package goagain
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// An Event is a lifecycle event reported by a Watcher.  Its name and fields
+// are those reported as MachineEvents; the last event a Watcher reports is
+// named "return" and carries the signal and error Wait would have returned.
+type Event struct {
+	Name   string
+	Fields map[string]string
+	Signal syscall.Signal
+	Err    error
+}
+
+// A Watcher awaits signals in the background as Wait does, reporting
+// lifecycle events on a channel, so restarts can be integrated into the
+// caller's own select loop alongside other shutdown triggers.
+type Watcher struct {
+	events chan Event
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Every Watcher, guarded by watchersMu since events are reported with mu
+// both held and not.
+var (
+	watchersMu sync.Mutex
+	watchers   []*Watcher
+)
+
+// Start awaiting signals in the background as Wait does with the given
+// listeners until the context is done or Stop is called.  Run only one
+// Watcher or Wait at a time.
+func Watch(ctx context.Context, ls ...net.Listener) *Watcher {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &Watcher{
+		events: make(chan Event, 16),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	watchersMu.Lock()
+	watchers = append(watchers, w)
+	watchersMu.Unlock()
+	go func() {
+		sig, err := WaitContext(ctx, ls...)
+		w.unwatch()
+		close(w.done)
+		w.events <- Event{Name: "return", Signal: sig, Err: err}
+		close(w.events)
+	}()
+	return w
+}
+
+// Return the channel on which lifecycle events are reported.  Events other
+// than the last are dropped rather than block if the channel is full.  The
+// channel is closed after the last event.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Stop awaiting signals and wait until signals are no longer handled.  The
+// last event, reporting the context's error, remains to be received.
+func (w *Watcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *Watcher) unwatch() {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	for i, o := range watchers {
+		if o == w {
+			watchers = append(watchers[:i], watchers[i+1:]...)
+			break
+		}
+	}
+}
+
+func notifyWatchers(name string, kv []interface{}) {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	if 0 == len(watchers) {
+		return
+	}
+	fields := make(map[string]string, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fields[fmt.Sprint(kv[i])] = fmt.Sprint(kv[i+1])
+	}
+	for _, w := range watchers {
+		select {
+		case w.events <- Event{Name: name, Fields: fields}:
+		default:
+		}
+	}
+}